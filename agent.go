@@ -0,0 +1,357 @@
+package kebench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	unitsMtx sync.RWMutex
+	registry = map[string]UnitFactory{}
+)
+
+// RegisterUnit makes factory available to agents under name, replacing
+// any unit registered under it before. Every worker of an agent gets a
+// unit of its own, as with BenchUnits.
+func RegisterUnit(name string, factory UnitFactory) {
+	unitsMtx.Lock()
+	defer unitsMtx.Unlock()
+	registry[name] = factory
+}
+
+// LookupUnit returns the unit factory registered under name.
+func LookupUnit(name string) (UnitFactory, bool) {
+	unitsMtx.RLock()
+	defer unitsMtx.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Agent runs the registered units a Coordinator asks for. Each job runs on
+// a copy of its Runner that takes the settings of the bench from the
+// coordinator, the rest of it, such as Metrics, HostInterval, Samples or
+// Pprof, stays the agent's own.
+//
+// The control protocol is a stream of JSON messages each way over TCP: a
+// job, then ready once the agent warmed up, start, optionally cancel, and
+// finally the records of the agent. An agent runs one job at a time.
+type Agent struct {
+	Runner *Runner
+	mtx    sync.Mutex
+}
+
+func NewAgent(r *Runner) *Agent {
+	return &Agent{Runner: r}
+}
+
+// ListenAndServe serves coordinators on addr until ctx is done.
+func (a *Agent) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if nil != err {
+		return err
+	}
+	return a.Serve(ctx, ln)
+}
+
+// Serve serves the coordinators connecting to ln until ctx is done, then
+// closes ln.
+func (a *Agent) Serve(ctx context.Context, ln net.Listener) error {
+	defer context.AfterFunc(ctx, func() { ln.Close() })()
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := ln.Accept()
+		if nil != err {
+			if nil != ctx.Err() {
+				return ctx.Err()
+			}
+			return err
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			a.serve(ctx, newEndpoint(conn))
+		}()
+	}
+}
+
+// serve runs the jobs of one coordinator until it hangs up.
+func (a *Agent) serve(ctx context.Context, e *endpoint) {
+	defer e.close()
+	for {
+		var msg message
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-e.in:
+			if !ok {
+				return
+			}
+			msg = m
+		}
+		if nil == msg.Job {
+			continue
+		}
+		records, err := a.run(ctx, e, *msg.Job)
+		reply := message{Records: records}
+		if nil != err && !errors.Is(err, context.Canceled) {
+			reply.Err = err.Error()
+		}
+		if nil != e.send(reply) {
+			return
+		}
+	}
+}
+
+// run benches one job, waiting for the coordinator to start it once the
+// warm-up is done.
+func (a *Agent) run(parent context.Context, e *endpoint, j job) (*wireRecords, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	factory, ok := LookupUnit(j.Unit)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownUnit, j.Unit)
+	}
+	p := plan{stages: j.Stages, total: j.Total, arrival: j.Arrival}
+	if err := (planned(p)).validate(); nil != err {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	// the job's settings stay with the job, the Runner is left as it was
+	copied := *a.Runner
+	r := &copied
+	r.Timeout, r.Interval, r.Precision, r.WarmUp = j.Timeout, j.Interval, j.Precision, j.WarmUp
+	if !validPrecision(r.Precision) {
+		return nil, ErrPrecision
+	}
+	if err := r.validateClock(); nil != err {
+		return nil, err
+	}
+	r.gate = func(ctx context.Context) error {
+		if err := e.send(message{Ready: true}); nil != err {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-e.in:
+			if !ok {
+				return io.ErrUnexpectedEOF
+			}
+			if !msg.Start {
+				return context.Canceled
+			}
+			// a cancel from now on ends the bench
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-e.in:
+					cancel()
+				}
+			}()
+			timer := time.NewTimer(msg.Delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+			return nil
+		}
+	}
+	stop, err := r.serveMetrics()
+	if nil != err {
+		return nil, err
+	}
+	defer stop()
+	records, err := r.trial(ctx, newWorkerUnits(factory), p, 0)
+	if nil != err {
+		return nil, err
+	}
+	for _, failure := range records.workers {
+		fmt.Println(failure.Error())
+	}
+	if nil != records.samples {
+		fmt.Println(records.samples.Error())
+	}
+	return wireOf(records), nil
+}
+
+// planned is a plan sent over the wire as a schedule.
+type planned plan
+
+func (p planned) validate() error {
+	if 0 == len(p.stages) {
+		return ErrNoStages
+	}
+	for _, s := range p.stages {
+		if s.Concurrency < 1 {
+			return ErrConcurrency
+		}
+		if s.Rate < 0 {
+			return ErrRate
+		}
+	}
+	if 0 == p.total && 0 == plan(p).duration() {
+		return ErrUnbounded
+	}
+	return nil
+}
+
+func (p planned) plan() plan { return plan(p) }
+
+// job is the share of a bench an agent runs.
+type job struct {
+	Unit      string
+	Stages    []Stage
+	Total     int64
+	Arrival   Arrival
+	Timeout   time.Duration
+	Interval  time.Duration
+	Precision int
+	WarmUp    WarmUp
+}
+
+// message is one message of the control protocol, of which only the
+// fields of its kind are set.
+type message struct {
+	Job     *job
+	Ready   bool
+	Start   bool
+	Delay   time.Duration
+	Cancel  bool
+	Records *wireRecords
+	Err     string
+}
+
+// endpoint is one side of a control connection. A single goroutine reads
+// the messages into in, which is closed when the connection fails.
+type endpoint struct {
+	conn net.Conn
+	mtx  sync.Mutex
+	enc  *json.Encoder
+	in   chan message
+}
+
+func newEndpoint(conn net.Conn) *endpoint {
+	e := &endpoint{conn: conn, enc: json.NewEncoder(conn), in: make(chan message, 4)}
+	go func() {
+		defer close(e.in)
+		dec := json.NewDecoder(conn)
+		for {
+			var msg message
+			if err := dec.Decode(&msg); nil != err {
+				return
+			}
+			e.in <- msg
+		}
+	}()
+	return e
+}
+
+func (e *endpoint) send(msg message) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.enc.Encode(msg)
+}
+
+func (e *endpoint) close() {
+	e.conn.Close()
+	for range e.in {
+	}
+}
+
+// wireTally is a tally sent over the wire.
+type wireTally struct {
+	Latency *Histogram
+	Errors  int64
+	Types   map[string]int64
+}
+
+// wireRecords are the records of an agent sent over the wire. Intervals
+// count from the start of the agent's bench, which the coordinator lines
+// up with the others.
+type wireRecords struct {
+	All       wireTally
+	Service   *Histogram
+	Queue     *Histogram
+	ByOp      []wireTally
+	ByStage   []wireTally
+	Intervals []wireTally
+	Limited   bool
+	Partial   bool
+	Leaks     LeakStats
+	Workers   []WorkerError
+	Ops       []string
+	Wall      time.Duration
+	StageWall []time.Duration
+	WarmUp    *WarmUpSummary
+	Clock     *ClockAccuracy
+}
+
+func wireTallies(tallies []tally) []wireTally {
+	out := make([]wireTally, len(tallies))
+	for i, t := range tallies {
+		out[i] = wireTally{Latency: t.latency, Errors: t.errors, Types: t.types}
+	}
+	return out
+}
+
+func (w wireTally) tally() tally {
+	return tally{latency: w.Latency, errors: w.Errors, types: w.Types}
+}
+
+func tallies(wire []wireTally) []tally {
+	out := make([]tally, len(wire))
+	for i, w := range wire {
+		out[i] = w.tally()
+	}
+	return out
+}
+
+func wireOf(records Records) *wireRecords {
+	return &wireRecords{
+		All:       wireTally{Latency: records.all.latency, Errors: records.all.errors, Types: records.all.types},
+		Service:   records.service,
+		Queue:     records.queue,
+		ByOp:      wireTallies(records.byOp),
+		ByStage:   wireTallies(records.byStage),
+		Intervals: wireTallies(records.intervals),
+		Limited:   records.limited,
+		Partial:   records.partial,
+		Leaks:     records.leak,
+		Workers:   records.workers,
+		Ops:       records.ops,
+		Wall:      records.wall,
+		StageWall: records.stageWall,
+		WarmUp:    records.warm,
+		Clock:     records.clock,
+	}
+}
+
+func (w *wireRecords) records() Records {
+	return Records{
+		all:       w.All.tally(),
+		service:   w.Service,
+		queue:     w.Queue,
+		byOp:      tallies(w.ByOp),
+		byStage:   tallies(w.ByStage),
+		intervals: tallies(w.Intervals),
+		limited:   w.Limited,
+		partial:   w.Partial,
+		leak:      w.Leaks,
+		workers:   w.Workers,
+		ops:       w.Ops,
+		wall:      w.Wall,
+		stageWall: w.StageWall,
+		warm:      w.WarmUp,
+		clock:     w.Clock,
+	}
+}
@@ -0,0 +1,184 @@
+package kebench
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells a Runner the time it measures requests with.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc turns a function like time.Now into a Clock.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time { return f() }
+
+// RealClock reads the system clock on every call.
+var RealClock Clock = ClockFunc(time.Now)
+
+// ClockAccuracy is how far a coarse clock can be trusted. Resolution is
+// the mean time between its updates and ErrorBound the longest, which is
+// how stale a reading can be. A latency taken as the difference of two
+// readings is off by less than ErrorBound either way.
+type ClockAccuracy struct {
+	Resolution time.Duration
+	ErrorBound time.Duration
+	Updates    int64
+}
+
+// CachedClock is a coarse clock: a ticker stores the system time every
+// tick and Now only loads it, which is far cheaper than reading the system
+// clock for handlers that take microseconds. The ticker rarely keeps up
+// with short ticks, Accuracy tells what it achieved.
+type CachedClock struct {
+	now  int64
+	tick time.Duration
+	stop chan struct{}
+	once sync.Once
+	mtx  sync.Mutex
+	acc  ClockAccuracy
+	sum  time.Duration
+}
+
+// NewCachedClock starts a clock updated every tick, to be stopped with
+// Stop once it is no longer used.
+func NewCachedClock(tick time.Duration) *CachedClock {
+	c := &CachedClock{tick: tick, stop: make(chan struct{})}
+	last := time.Now()
+	atomic.StoreInt64(&c.now, last.UnixNano())
+	ticker := time.NewTicker(tick)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			atomic.StoreInt64(&c.now, now.UnixNano())
+			gap := now.Sub(last)
+			last = now
+			c.mtx.Lock()
+			c.acc.Updates++
+			c.sum += gap
+			c.acc.ErrorBound = max(c.acc.ErrorBound, gap)
+			c.mtx.Unlock()
+		}
+	}()
+	return c
+}
+
+func (c *CachedClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.now))
+}
+
+// Stop stops updating the clock.
+func (c *CachedClock) Stop() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+// Accuracy is how accurate the clock was so far, its tick until it
+// updated for the first time.
+func (c *CachedClock) Accuracy() ClockAccuracy {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	acc := c.acc
+	if 0 == acc.Updates {
+		return ClockAccuracy{Resolution: c.tick, ErrorBound: c.tick}
+	}
+	acc.Resolution = c.sum / time.Duration(acc.Updates)
+	return acc
+}
+
+// ManualClock only moves when told to, for tests of what reads a Clock.
+// A Runner refuses it with ErrManualClock: its timers, tickers and sleeps
+// run on the system clock and would not line up with it.
+type ManualClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *ManualClock) Set(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock on by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// coarseShare is the share of the median latency above which the error
+// bound of the clock makes a report coarse.
+const coarseShare = 0.1
+
+// ClockReport is the clock a bench was measured with. Accuracy is set for
+// clocks that know it, Coarse when its error bound exceeds a tenth of the
+// median latency, so the percentiles are no better than the clock.
+type ClockReport struct {
+	Accuracy *ClockAccuracy
+	Coarse   bool
+}
+
+func (r *Runner) validateClock() error {
+	if _, ok := r.Clock.(*ManualClock); ok {
+		return ErrManualClock
+	}
+	return nil
+}
+
+// accuracy is that of the clock of the Runner, nil when it does not know.
+func (r *Runner) accuracy() *ClockAccuracy {
+	accurate, ok := r.Clock.(interface{ Accuracy() ClockAccuracy })
+	if !ok {
+		return nil
+	}
+	acc := accurate.Accuracy()
+	return &acc
+}
+
+// coarser is the less accurate of two clocks, either unknown one left out.
+func coarser(a, b *ClockAccuracy) *ClockAccuracy {
+	if nil == a || (nil != b && b.ErrorBound > a.ErrorBound) {
+		return b
+	}
+	return a
+}
+
+// clockReport is what a clock of accuracy acc tells of a bench whose
+// median latency was median, nil when its accuracy is unknown.
+func clockReport(acc *ClockAccuracy, median time.Duration) *ClockReport {
+	if nil == acc {
+		return nil
+	}
+	return &ClockReport{
+		Accuracy: acc,
+		Coarse:   float64(acc.ErrorBound) > coarseShare*float64(median),
+	}
+}
+
+func (c *ClockReport) write(t *textWriter) {
+	t.printf("Clock: resolution %v, error bound %v over %d updates\n",
+		c.Accuracy.Resolution, c.Accuracy.ErrorBound, c.Accuracy.Updates)
+	if c.Coarse {
+		t.printf("COARSE CLOCK: the error bound exceeds %g%% of the median latency\n", coarseShare*100)
+	}
+}
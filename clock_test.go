@@ -0,0 +1,114 @@
+package kebench_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(100, 0)
+	c := kebench.NewManualClock(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("now %v", c.Now())
+	}
+	c.Advance(time.Second)
+	if !c.Now().Equal(start.Add(time.Second)) {
+		t.Errorf("advanced %v", c.Now())
+	}
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("set %v", c.Now())
+	}
+}
+
+func TestCachedClock(t *testing.T) {
+	c := kebench.NewCachedClock(time.Millisecond)
+	defer c.Stop()
+	if acc := c.Accuracy(); acc.ErrorBound < time.Millisecond {
+		t.Errorf("accuracy before updating %+v", acc)
+	}
+	first := c.Now()
+	time.Sleep(50 * time.Millisecond)
+	if !c.Now().After(first) {
+		t.Errorf("not updated: %v %v", first, c.Now())
+	}
+	acc := c.Accuracy()
+	if 0 == acc.Updates || acc.Resolution <= 0 || acc.ErrorBound < acc.Resolution {
+		t.Errorf("accuracy %+v", acc)
+	}
+	if lag := time.Since(c.Now()); lag < 0 || lag > acc.ErrorBound+50*time.Millisecond {
+		t.Errorf("lag %v, bound %v", lag, acc.ErrorBound)
+	}
+	c.Stop()
+	c.Stop()
+}
+
+func TestReportClock(t *testing.T) {
+	r := quietRunner(t)
+	rep, err := r.RunFor(context.Background(), &countUnit{}, 2, 50*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != rep.Clock {
+		t.Errorf("clock report for the system clock %+v", rep.Clock)
+	}
+
+	// a clock far coarser than the handlers
+	c := kebench.NewCachedClock(20 * time.Millisecond)
+	defer c.Stop()
+	r.Clock = c
+	if rep, err = r.RunFor(context.Background(), &countUnit{sleep: time.Millisecond}, 2, 200*time.Millisecond); nil != err {
+		t.Fatal(err)
+	}
+	if nil == rep.Clock || nil == rep.Clock.Accuracy || !rep.Clock.Coarse {
+		t.Fatalf("clock report %+v", rep.Clock)
+	}
+	if rep.Clock.Accuracy.ErrorBound < 10*time.Millisecond {
+		t.Errorf("accuracy %+v", rep.Clock.Accuracy)
+	}
+}
+
+func TestRunnerManualClock(t *testing.T) {
+	r := quietRunner(t)
+	r.Clock = kebench.NewManualClock(time.Unix(100, 0))
+	u := &countUnit{}
+	rep, err := r.RunLoad(context.Background(), u, kebench.Load{Concurrency: 2, Duration: 500 * time.Millisecond, Rate: 200})
+	if !errors.Is(err, kebench.ErrManualClock) || nil != rep || 0 != u.runs {
+		t.Errorf("report %v, err %v, runs %d", rep, err, u.runs)
+	}
+	if _, err = r.Search(context.Background(), kebench.Adapt(u), kebench.Search{
+		SLO: kebench.SLO{Percentile: 0.99, Latency: time.Millisecond}, Min: 1, Max: 2, Step: 10 * time.Millisecond,
+	}); !errors.Is(err, kebench.ErrManualClock) {
+		t.Errorf("search err %v", err)
+	}
+}
+
+func TestReanalyzeClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bench.kebs")
+	c := kebench.NewCachedClock(20 * time.Millisecond)
+	defer c.Stop()
+	r := quietRunner(t)
+	r.Clock = c
+	r.Samples = kebench.SampleFile{Path: path}
+	rep, err := r.RunFor(context.Background(), &countUnit{sleep: time.Millisecond}, 2, 100*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil == rep.Clock {
+		t.Fatal("no clock report")
+	}
+
+	// reanalyzed with the system clock, reported with the one measured with
+	back, err := kebench.NewRunner(time.Now).Reanalyze(path, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil == back.Clock || *back.Clock.Accuracy != *rep.Clock.Accuracy || back.Clock.Coarse != rep.Clock.Coarse {
+		t.Errorf("reanalyzed clock %+v, want %+v", back.Clock, rep.Clock)
+	}
+}
@@ -0,0 +1,54 @@
+// Command kecompare tells whether two benches differ. Each side is a
+// report written with -o json=path, a sample file written with -samples
+// or a file of raw latencies, one per line:
+//
+//	kecompare [-alpha 0.05] [-p 0.5,0.9,0.99] old.json new.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func main() {
+	alpha := flag.Float64("alpha", kebench.DefaultAlpha, "significance level")
+	list := flag.String("p", "0.5,0.9,0.99,0.999", "comma separated percentiles to compare")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: kecompare [flags] old new\n"+
+			"each side a json report, a sample file or raw latencies, one per line\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if 2 != flag.NArg() {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var percentiles []float64
+	for _, field := range strings.Split(*list, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if nil != err || q <= 0 || q > 1 {
+			fmt.Fprintf(os.Stderr, "bad percentile %q\n", field)
+			os.Exit(2)
+		}
+		percentiles = append(percentiles, q)
+	}
+	before, err := kebench.LoadSample(flag.Arg(0))
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	after, err := kebench.LoadSample(flag.Arg(1))
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := kebench.Compare(before, after, percentiles, *alpha).WriteText(os.Stdout); nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,280 @@
+package kebench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultAlpha is the significance level of a comparison.
+const DefaultAlpha = 0.05
+
+// Sample is one side of a comparison: the latencies of a bench and, when
+// known, its throughput overall and per interval.
+type Sample struct {
+	Name      string
+	Latency   *Histogram
+	TPS       float64
+	Intervals []float64
+}
+
+// SampleOf takes the sample of rep. A report without a Distribution falls
+// back to its Histogram buckets.
+func SampleOf(name string, rep *Report) Sample {
+	s := Sample{Name: name, Latency: rep.Distribution, TPS: rep.TPS}
+	if nil == s.Latency {
+		s.Latency = NewHistogram(DefaultPrecision)
+		for _, b := range rep.Histogram {
+			s.Latency.recordN(int64(b.Upper), b.Count)
+		}
+	}
+	for _, in := range rep.Intervals {
+		// a short last interval says little about the throughput
+		if 2*in.Duration >= rep.Params.Interval {
+			s.Intervals = append(s.Intervals, in.TPS)
+		}
+	}
+	return s
+}
+
+// LoadSample reads a sample from path, either a report written as JSON,
+// a sample file or raw latencies, one per line as a duration like 1.5ms
+// or a number of nanoseconds. Blank lines and lines starting with # are
+// skipped.
+func LoadSample(path string) (Sample, error) {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return Sample{}, err
+	}
+	if bytes.HasPrefix(data, []byte(sampleMagic)) || bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r := NewRunner(nil)
+		rep, err := r.Reanalyze(path, nil)
+		if nil != err {
+			return Sample{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return SampleOf(path, rep), nil
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var rep Report
+		if err := json.Unmarshal(data, &rep); nil != err {
+			return Sample{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return SampleOf(path, &rep), nil
+	}
+	s := Sample{Name: path, Latency: NewHistogram(DefaultPrecision)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if "" == text || strings.HasPrefix(text, "#") {
+			continue
+		}
+		v, err := parseLatency(text)
+		if nil != err {
+			return Sample{}, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.Latency.Record(v)
+	}
+	return s, scanner.Err()
+}
+
+func parseLatency(text string) (int64, error) {
+	if d, err := time.ParseDuration(text); nil == err {
+		return int64(d), nil
+	}
+	ns, err := strconv.ParseFloat(text, 64)
+	if nil != err {
+		return 0, fmt.Errorf("bad latency %q", text)
+	}
+	return int64(ns), nil
+}
+
+// Delta is how one statistic changed from Old to New. P is the p-value of
+// the test that the two differ, 1 when there is too little to test, and
+// Significant whether it is below the comparison's alpha.
+type Delta struct {
+	Name        string
+	Old         float64
+	New         float64
+	Change      float64
+	P           float64
+	Significant bool
+}
+
+// Comparison is the difference between two samples. TPS is nil unless
+// both know their throughput. The mean latency is tested with a
+// Mann-Whitney U test over all requests, TPS with one over the interval
+// throughputs and each percentile by splitting both samples at the pooled
+// percentile, a generalised Mood's median test.
+type Comparison struct {
+	Old         string
+	New         string
+	Alpha       float64
+	Requests    [2]int64
+	TPS         *Delta
+	Latency     Delta
+	Percentiles []Delta
+}
+
+// Compare compares after against before at the given percentiles.
+func Compare(before, after Sample, percentiles []float64, alpha float64) Comparison {
+	c := Comparison{
+		Old:      before.Name,
+		New:      after.Name,
+		Alpha:    alpha,
+		Requests: [2]int64{before.Latency.Count(), after.Latency.Count()},
+	}
+	if 0 != before.TPS && 0 != after.TPS {
+		p := 1.0
+		if 1 < len(before.Intervals) && 1 < len(after.Intervals) {
+			p = mannWhitney(valueBins(before.Intervals), valueBins(after.Intervals))
+		}
+		delta := newDelta("TPS", before.TPS, after.TPS, p, alpha)
+		c.TPS = &delta
+	}
+	c.Latency = newDelta("mean", before.Latency.Mean(), after.Latency.Mean(),
+		mannWhitney(histogramBins(before.Latency), histogramBins(after.Latency)), alpha)
+	for _, q := range percentiles {
+		c.Percentiles = append(c.Percentiles, newDelta(
+			"P"+strconv.FormatFloat(q*100, 'g', -1, 64),
+			float64(before.Latency.ValueAt(q)), float64(after.Latency.ValueAt(q)),
+			quantileTest(before.Latency, after.Latency, q), alpha))
+	}
+	return c
+}
+
+func newDelta(name string, before, after, p, alpha float64) Delta {
+	d := Delta{Name: name, Old: before, New: after, P: p, Significant: p < alpha}
+	if 0 != before {
+		d.Change = (after - before) / before
+	}
+	return d
+}
+
+// bin is a value and how often it occurs.
+type bin struct {
+	v float64
+	n int64
+}
+
+func valueBins(values []float64) []bin {
+	bins := make([]bin, len(values))
+	for i, v := range values {
+		bins[i] = bin{v: v, n: 1}
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].v < bins[j].v })
+	return bins
+}
+
+func histogramBins(h *Histogram) []bin {
+	var bins []bin
+	h.each(func(upper, count int64) {
+		bins = append(bins, bin{v: float64(upper), n: count})
+	})
+	return bins
+}
+
+// mannWhitney is the two-sided p-value of a Mann-Whitney U test between
+// the sorted bins a and b, by the normal approximation corrected for ties.
+func mannWhitney(a, b []bin) float64 {
+	var (
+		n1, n2   float64
+		seen, ra float64
+		ties     float64
+		i, j     int
+	)
+	for i < len(a) || j < len(b) {
+		var ca, cb float64
+		switch {
+		case j == len(b) || i < len(a) && a[i].v < b[j].v:
+			ca = float64(a[i].n)
+			i++
+		case i == len(a) || b[j].v < a[i].v:
+			cb = float64(b[j].n)
+			j++
+		default:
+			ca, cb = float64(a[i].n), float64(b[j].n)
+			i++
+			j++
+		}
+		t := ca + cb
+		// tied values share the mean of the ranks they span
+		ra += ca * (seen + (t+1)/2)
+		ties += t*t*t - t
+		seen += t
+		n1 += ca
+		n2 += cb
+	}
+	n := n1 + n2
+	if 0 == n1 || 0 == n2 {
+		return 1
+	}
+	u := ra - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (u - n1*n2/2) / math.Sqrt(variance)
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// quantileTest is the two-sided p-value that a and b share their q-th
+// quantile: both are split at the pooled quantile and the shares at or
+// below it compared with a two-proportion z-test.
+func quantileTest(a, b *Histogram, q float64) float64 {
+	if 0 == a.n || 0 == b.n {
+		return 1
+	}
+	pooled := &Histogram{bits: a.bits}
+	pooled.Merge(a)
+	pooled.Merge(b)
+	v := pooled.ValueAt(q)
+	na, nb := float64(a.n), float64(b.n)
+	pa, pb := float64(a.rank(v))/na, float64(b.rank(v))/nb
+	p := (pa*na + pb*nb) / (na + nb)
+	se := math.Sqrt(p * (1 - p) * (1/na + 1/nb))
+	if 0 == se {
+		return 1
+	}
+	return math.Erfc(math.Abs(pa-pb) / se / math.Sqrt2)
+}
+
+// WriteText renders the comparison as a table, changes that are not
+// significant at Alpha shown as ~.
+func (c Comparison) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	t := &textWriter{w: tw}
+	t.printf("old: %s (%d requests)\nnew: %s (%d requests)\n\n", c.Old, c.Requests[0], c.New, c.Requests[1])
+	t.printf("\told\tnew\tdelta\t\n")
+	if nil != c.TPS {
+		writeDelta(t, *c.TPS, func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) })
+	}
+	latency := func(v float64) string { return roundDuration(time.Duration(v)).String() }
+	writeDelta(t, c.Latency, latency)
+	for _, d := range c.Percentiles {
+		writeDelta(t, d, latency)
+	}
+	if nil != t.err {
+		return t.err
+	}
+	return tw.Flush()
+}
+
+func writeDelta(t *textWriter, d Delta, format func(float64) string) {
+	change := "~"
+	if d.Significant {
+		change = strconv.FormatFloat(d.Change*100, 'f', 2, 64) + "%"
+		if d.Change >= 0 {
+			change = "+" + change
+		}
+	}
+	t.printf("%s\t%s\t%s\t%s\t(p=%.3f)\n", d.Name, format(d.Old), format(d.New), change, d.P)
+}
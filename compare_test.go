@@ -0,0 +1,115 @@
+package kebench_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func latencySample(name string, rng *rand.Rand, base time.Duration, n int) kebench.Sample {
+	h := kebench.NewHistogram(3)
+	for i := 0; i < n; i++ {
+		h.Record(int64(base) + int64(rng.ExpFloat64()*float64(base)/4))
+	}
+	return kebench.Sample{Name: name, Latency: h}
+}
+
+func TestCompare(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	old := latencySample("old", rng, time.Millisecond, 5000)
+	same := latencySample("same", rng, time.Millisecond, 5000)
+	slower := latencySample("slower", rng, 1100*time.Microsecond, 5000)
+	percentiles := []float64{0.5, 0.99}
+
+	c := kebench.Compare(old, same, percentiles, kebench.DefaultAlpha)
+	if c.Latency.Significant || c.Percentiles[0].Significant {
+		t.Errorf("same distribution differs: %+v", c)
+	}
+	c = kebench.Compare(old, slower, percentiles, kebench.DefaultAlpha)
+	if !c.Latency.Significant || !c.Percentiles[0].Significant || c.Percentiles[0].Change < 0.05 {
+		t.Errorf("slower distribution does not differ: %+v", c)
+	}
+	if nil != c.TPS {
+		t.Errorf("raw samples compare throughput %+v", c.TPS)
+	}
+	var buf bytes.Buffer
+	if err := c.WriteText(&buf); nil != err {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "P50") || !strings.Contains(buf.String(), "+") {
+		t.Errorf("text %s", buf.String())
+	}
+}
+
+func TestLoadSample(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.txt")
+	if err := os.WriteFile(raw, []byte("# latencies\n1ms\n2000000\n\n3ms\n"), 0o644); nil != err {
+		t.Fatal(err)
+	}
+	s, err := kebench.LoadSample(raw)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != s.Latency.Count() || int64(2*time.Millisecond) != int64(s.Latency.Mean()) {
+		t.Errorf("raw sample of %d, mean %v", s.Latency.Count(), s.Latency.Mean())
+	}
+
+	rep := benchReport(t)
+	path := filepath.Join(dir, "report.json")
+	f, err := os.Create(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := rep.WriteJSON(f); nil != err {
+		t.Fatal(err)
+	}
+	f.Close()
+	s, err = kebench.LoadSample(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if s.Latency.Count() != rep.Requests || s.Latency.ValueAt(0.99) != int64(rep.Latency.Percentiles[len(rep.Latency.Percentiles)-1].Value) || s.TPS != rep.TPS {
+		t.Errorf("report sample of %d, p99 %d, tps %f", s.Latency.Count(), s.Latency.ValueAt(0.99), s.TPS)
+	}
+}
+
+func TestLoadSampleWorkers(t *testing.T) {
+	r := quietRunner(t)
+	rep, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+		if 1 == id {
+			return nil, errors.New("no seat")
+		}
+		return kebench.Adapt(&countUnit{}), nil
+	}, kebench.Load{Concurrency: 2, Total: 100})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1 != len(rep.Workers) {
+		t.Fatalf("workers %v", rep.Workers)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	f, err := os.Create(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := rep.WriteJSON(f); nil != err {
+		t.Fatal(err)
+	}
+	f.Close()
+	s, err := kebench.LoadSample(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if s.Latency.Count() != rep.Requests {
+		t.Errorf("report sample of %d, want %d", s.Latency.Count(), rep.Requests)
+	}
+}
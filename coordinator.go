@@ -0,0 +1,208 @@
+package kebench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultLead is how long a new Coordinator gives the agents to start
+// together.
+const DefaultLead = 200 * time.Millisecond
+
+// Coordinator benches a registered unit on several agents at once and
+// reports them as one. The load is split evenly: every agent runs each
+// stage with its share of the concurrency, at least one worker, of the
+// rate and of the total. A total smaller than the agents leaves the last
+// ones idle.
+//
+// Agents warm up on their own, then all start Lead after the last one is
+// ready. Intervals are lined up by the start of each agent, so they agree
+// to within the network latency of the start message.
+type Coordinator struct {
+	// Runner supplies the settings of the bench and writes the report.
+	Runner *Runner
+	Agents []string
+	Lead   time.Duration
+}
+
+func NewCoordinator(r *Runner, agents ...string) *Coordinator {
+	return &Coordinator{Runner: r, Agents: agents, Lead: DefaultLead}
+}
+
+// Bench runs the unit registered under name on every agent following
+// schedule. A cancelled ctx still yields the report of what the agents
+// completed, marked Partial, together with the ctx error.
+func (c *Coordinator) Bench(ctx context.Context, name string, schedule Schedule) (*Report, error) {
+	r := c.Runner
+	if 0 == len(c.Agents) {
+		return nil, ErrNoAgents
+	}
+	if err := schedule.validate(); nil != err {
+		return nil, err
+	}
+	if err := validatePercentiles(r.Percentiles, r.PercentileMethod); nil != err {
+		return nil, err
+	}
+	if !validPrecision(r.Precision) {
+		return nil, ErrPrecision
+	}
+	if err := r.validateClock(); nil != err {
+		return nil, err
+	}
+	p := schedule.plan()
+	// a total is not split finer than a request per agent
+	addrs := c.Agents
+	if 0 != p.total && p.total < int64(len(addrs)) {
+		addrs = addrs[:p.total]
+		fmt.Printf("%d of %d agents idle, the total is %d\n", len(c.Agents)-len(addrs), len(c.Agents), p.total)
+	}
+	agents := make([]*endpoint, 0, len(addrs))
+	defer func() {
+		for _, e := range agents {
+			e.close()
+		}
+	}()
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if nil != err {
+			return nil, err
+		}
+		agents = append(agents, newEndpoint(conn))
+	}
+	for i, e := range agents {
+		share := p.share(i, len(agents))
+		err := e.send(message{Job: &job{
+			Unit:      name,
+			Stages:    share.stages,
+			Total:     share.total,
+			Arrival:   share.arrival,
+			Timeout:   r.Timeout,
+			Interval:  r.Interval,
+			Precision: r.Precision,
+			WarmUp:    r.WarmUp,
+		}})
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("waiting for %d agents\n", len(agents))
+	for i, e := range agents {
+		msg, err := c.receive(ctx, e, i)
+		if nil == err && !msg.Ready {
+			err = fmt.Errorf("agent %s: not ready", c.Agents[i])
+		}
+		if nil != err {
+			c.broadcast(agents, message{Cancel: true})
+			return nil, err
+		}
+	}
+	c.broadcast(agents, message{Start: true, Delay: c.Lead})
+	begin := r.Clock.Now().Add(c.Lead)
+	fmt.Println("start bench")
+	defer context.AfterFunc(ctx, func() {
+		c.broadcast(agents, message{Cancel: true})
+	})()
+
+	var (
+		merged Records
+		errs   []error
+	)
+	for i, e := range agents {
+		// the records come back whether or not ctx is done
+		msg, err := c.receive(context.Background(), e, i)
+		if nil != err {
+			errs = append(errs, err)
+			continue
+		}
+		records := msg.Records.records()
+		if 0 == i || nil == merged.all.latency {
+			merged = records
+			continue
+		}
+		merged.combine(records)
+	}
+	if nil == merged.all.latency {
+		return nil, errors.Join(append(errs, ctx.Err())...)
+	}
+	merged.partial = merged.partial || nil != ctx.Err() || 0 != len(errs)
+	merged.params = r.params(p, begin)
+	report := r.report(merged, p)
+	if err := r.output(report); nil != err {
+		errs = append(errs, err)
+	}
+	return report, errors.Join(append(errs, ctx.Err())...)
+}
+
+// receive waits for the next message of the i-th agent, failing on the
+// errors it reports.
+func (c *Coordinator) receive(ctx context.Context, e *endpoint, i int) (message, error) {
+	select {
+	case <-ctx.Done():
+		return message{}, ctx.Err()
+	case msg, ok := <-e.in:
+		switch {
+		case !ok:
+			return msg, fmt.Errorf("agent %s: %w", c.Agents[i], ErrAgentLost)
+		case "" != msg.Err:
+			return msg, fmt.Errorf("agent %s: %s", c.Agents[i], msg.Err)
+		case !msg.Ready && nil == msg.Records:
+			return msg, fmt.Errorf("agent %s: canceled", c.Agents[i])
+		}
+		return msg, nil
+	}
+}
+
+func (c *Coordinator) broadcast(agents []*endpoint, msg message) {
+	for _, e := range agents {
+		e.send(msg)
+	}
+}
+
+// share is the i-th of n even shares of the plan.
+func (p plan) share(i, n int) plan {
+	part := func(total int64) int64 {
+		v := total / int64(n)
+		if int64(i) < total%int64(n) {
+			v++
+		}
+		return v
+	}
+	share := plan{total: part(p.total), arrival: p.arrival}
+	for _, s := range p.stages {
+		s.Concurrency = max(1, int(part(int64(s.Concurrency))))
+		s.Rate /= float64(n)
+		share.stages = append(share.stages, s)
+	}
+	return share
+}
+
+// combine adds the records of another agent, which ran at the same time.
+func (rs *Records) combine(o Records) {
+	wall := max(rs.wall, o.wall)
+	stageWall := append([]time.Duration(nil), rs.stageWall...)
+	for i, w := range o.stageWall {
+		if i < len(stageWall) {
+			stageWall[i] = max(stageWall[i], w)
+		}
+	}
+	intervals := rs.intervals
+	for i, t := range o.intervals {
+		for len(intervals) <= i {
+			intervals = append(intervals, tally{})
+		}
+		intervals[i].merge(t)
+	}
+	var (
+		warm     = rs.warm
+		workers  = append(rs.workers, o.workers...)
+		inflight = rs.leak.InFlight + o.leak.InFlight
+	)
+	rs.merge(o)
+	rs.wall, rs.stageWall, rs.intervals, rs.warm = wall, stageWall, intervals, warm
+	rs.workers, rs.leak.InFlight = workers, inflight
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,157 +16,545 @@ type Unit interface {
 	End() error
 }
 
+// ContextUnit is a Unit whose requests receive the per-request context.
+// The context carries the Runner's Timeout as its deadline, so a handler
+// can pass it on to net.Conn.SetDeadline and similar calls and give up on
+// its own instead of being abandoned.
+type ContextUnit interface {
+	WarmUp(ctx context.Context) error
+	Run(ctx context.Context) error
+	Begin() error
+	End() error
+}
+
+// Adapt turns a Unit into a ContextUnit whose handlers ignore the context.
+func Adapt(unit Unit) ContextUnit {
+	return adapted{unit: unit}
+}
+
+type adapted struct {
+	unit Unit
+}
+
+func (a adapted) WarmUp(context.Context) error { return a.unit.WarmUp() }
+func (a adapted) Run(context.Context) error    { return a.unit.Run() }
+func (a adapted) Begin() error                 { return a.unit.Begin() }
+func (a adapted) End() error                   { return a.unit.End() }
+
+// Schedule is the shape of a bench run, either a Load or a Profile.
+type Schedule interface {
+	validate() error
+	plan() plan
+}
+
 type Runner struct {
-	Now     func() time.Time
-	records Records
+	// Clock times the requests, see CachedClock for handlers so short that
+	// reading the system clock weighs on them. A ManualClock is refused.
+	Clock Clock
+	// Timeout bounds each request, 0 disables it.
+	Timeout time.Duration
+	// MaxAbandoned caps the handlers left running by timed out requests,
+	// 0 disables the cap. Workers wait for abandoned handlers to return
+	// once it is reached, or end the phase when StopOnAbandoned is set.
+	MaxAbandoned    int
+	StopOnAbandoned bool
+	// WarmUp configures the phase run before the measurement, the zero
+	// value skips it.
+	WarmUp WarmUp
+	// Percentiles lists the quantiles a Report gives, e.g. 0.99, read off
+	// by PercentileMethod.
+	Percentiles      []float64
+	PercentileMethod PercentileMethod
+	// Outputs are where the report is written once a bench is done.
+	Outputs []Output
+	// Interval is the resolution of the time series in a Report, Live
+	// prints a line for each interval while the bench runs.
+	Interval time.Duration
+	Live     bool
+	// Trials repeats the warm-up and bench, pausing Cooldown in between,
+	// and reports how much the trials agree.
+	Trials   int
+	Cooldown time.Duration
+	// Precision is how many significant digits, 1 to 5, the latency
+	// histograms keep.
+	Precision int
+	// Samples is where every measured request is written, see SampleFile.
+	// Trials number the file before its extension. A file that fails to be
+	// written does not cost the report, which is returned with an error
+	// wrapping ErrSampleWrite.
+	Samples SampleFile
+	// Metrics is the address, e.g. ":9100", to serve live metrics on at
+	// /metrics while a bench runs, empty for none. See MetricsHandler.
+	Metrics string
+	// HostInterval is how often the host is sampled while the units are
+	// begun, 0 disables it.
+	HostInterval time.Duration
+	// Pprof configures the pprof profiles captured during the bench.
+	Pprof   Pprof
+	leaks   leaks
+	metrics *metrics
+	// gate holds the bench back after the warm-up until it returns
+	gate func(ctx context.Context) error
 }
 
 func NewRunner(now func() time.Time) *Runner {
+	clock := RealClock
+	if nil != now {
+		clock = ClockFunc(now)
+	}
 	return &Runner{
-		Now: now,
+		Clock:        clock,
+		Timeout:      time.Second,
+		MaxAbandoned: DefaultMaxAbandoned,
+		WarmUp:       DefaultWarmUp,
+		Percentiles:  DefaultPercentiles,
+		Outputs:      []Output{{Format: "text"}},
+		Interval:     DefaultInterval,
+		Live:         true,
+		Precision:    DefaultPrecision,
+		HostInterval: DefaultHostInterval,
 	}
 }
 
+// Records is what a phase recorded, merged from the recorders of all its
+// workers.
 type Records struct {
-	entry []RecordEntry
+	all       tally
+	service   *Histogram
+	queue     *Histogram
+	byOp      []tally
+	byStage   []tally
+	intervals []tally
+	// limited is set when the abandoned handler limit ended the phase
+	limited bool
+	leak    LeakStats
+	workers []WorkerError
+	// ops names the operations of a scenario, nil for plain units
+	ops []string
+	// partial is set when the run was cancelled before it was done
+	partial bool
+	wall    time.Duration
+	// stageWall is how long each stage ran
+	stageWall []time.Duration
+	params    Params
+	warm      *WarmUpSummary
+	host      []HostSample
+	runtime   *runtimeRecords
+	profiles  []string
+	// samples is why the sample file could not be written, if it could not
+	samples error
+	// clock is the accuracy of a coarse clock, nil for others
+	clock *ClockAccuracy
+	// idle is set when no worker could be set up
+	idle bool
 }
 
+// RecordEntry is the outcome of one request. Cost is the service time
+// spent in the handler, Wait is how long an open-loop request sat queued
+// after its intended start, always zero for closed-loop loads. Stage is
+// the index of the profile stage the request started in and Op that of
+// the scenario operation it issued. At is when it completed, counted
+// from the start of the phase.
 type RecordEntry struct {
-	Cost int64
-	Err  error
+	Cost  int64
+	Wait  int64
+	At    int64
+	Stage int
+	Op    int
+	Err   error
+}
+
+// Latency is the time from intended start to completion.
+func (e RecordEntry) Latency() int64 {
+	return e.Wait + e.Cost
 }
 
 type Handler func() error
 
-func (r *Runner) Run(ctx context.Context, unit Unit, concurrency int, total int64) error {
+type ContextHandler func(ctx context.Context) error
 
-	fmt.Println("start warmup")
-	// warm up
-	r.benching(ctx, unit.WarmUp, concurrency, total)
+// Run issues total requests spread over concurrency workers.
+func (r *Runner) Run(ctx context.Context, unit Unit, concurrency int, total int64) (*Report, error) {
+	return r.RunLoad(ctx, unit, Load{Concurrency: concurrency, Total: total})
+}
 
-	fmt.Println("start bench")
-	if err := unit.Begin(); nil != err {
-		return err
+// RunFor keeps concurrency workers busy until duration has elapsed.
+func (r *Runner) RunFor(ctx context.Context, unit Unit, concurrency int, duration time.Duration) (*Report, error) {
+	return r.RunLoad(ctx, unit, Load{Concurrency: concurrency, Duration: duration})
+}
+
+// RunLoad warms up and benches unit with the given load.
+func (r *Runner) RunLoad(ctx context.Context, unit Unit, load Load) (*Report, error) {
+	return r.Bench(ctx, Adapt(unit), load)
+}
+
+// Bench warms up and benches unit following schedule. A cancelled ctx
+// still yields the report of what completed, marked Partial, together
+// with the ctx error.
+func (r *Runner) Bench(ctx context.Context, unit ContextUnit, schedule Schedule) (*Report, error) {
+	if err := validateUnit(unit); nil != err {
+		return nil, err
 	}
-	begin := r.Now()
-	// running
-	r.benching(ctx, unit.Run, concurrency, total)
-	end := r.Now()
-	if err := unit.End(); nil != err {
-		return err
-	}
-	cost := end.Sub(begin)
-	fmt.Printf("bench cost %v\n", cost)
-	r.report(cost)
-	return nil
-}
-
-func (r *Runner) report(cost time.Duration) {
-	var totalCost int64
-	var totalErrors int64
-	for _, entry := range r.records.entry {
-		totalCost += entry.Cost
-		if entry.Err != nil {
-			totalErrors++
-		}
+	return r.measure(ctx, &sharedUnit{unit: unit}, schedule)
+}
+
+func (r *Runner) measure(ctx context.Context, units units, schedule Schedule) (*Report, error) {
+	if err := schedule.validate(); nil != err {
+		return nil, err
+	}
+	p := schedule.plan()
+	if err := r.WarmUp.validate(p); nil != err {
+		return nil, err
+	}
+	if err := validatePercentiles(r.Percentiles, r.PercentileMethod); nil != err {
+		return nil, err
+	}
+	if !validPrecision(r.Precision) {
+		return nil, ErrPrecision
+	}
+	if err := r.Pprof.validate(); nil != err {
+		return nil, err
 	}
-	errorTypes := make(map[string]int)
-	for _, entry := range r.records.entry {
-		if entry.Err != nil {
-			errorTypes[entry.Err.Error()]++
+	if err := r.validateClock(); nil != err {
+		return nil, err
+	}
+	stop, err := r.serveMetrics()
+	if nil != err {
+		return nil, err
+	}
+	defer stop()
+	if r.Trials <= 1 {
+		records, err := r.trial(ctx, units, p, 0)
+		if nil != err {
+			return nil, err
+		}
+		report := r.report(records, p)
+		if err := r.output(report); nil != err {
+			return report, err
 		}
+		if nil != ctx.Err() {
+			return report, ctx.Err()
+		}
+		return report, records.samples
 	}
+	return r.repeat(ctx, units, p)
+}
 
-	avgCost := float64(totalCost) / float64(len(r.records.entry))
-	errorRate := float64(totalErrors) / float64(len(r.records.entry))
-
-	fmt.Printf("Total Requests: %d\n", len(r.records.entry))
-	fmt.Printf("Total Cost: %v\n", cost)
-	fmt.Printf("Total Sum : %dns, %v\n", totalCost, time.Duration(totalCost))
-	fmt.Printf("Average Cost: %.2f, %v\n", avgCost, time.Duration(avgCost))
-	if 0 != len(errorTypes) {
-		fmt.Println("Error Types:")
-		for err, count := range errorTypes {
-			fmt.Printf("%s: %d\n", err, count)
+// trial warms up and benches once as the i-th trial.
+func (r *Runner) trial(ctx context.Context, units units, p plan, i int) (Records, error) {
+	var warm *WarmUpSummary
+	if r.WarmUp.enabled() {
+		fmt.Println("start warmup")
+		r.metrics.enter(phaseWarmUp)
+		summary, err := r.warmUp(ctx, units.handlers(true), p)
+		if nil != err {
+			units.end()
+			return Records{}, err
 		}
+		summary.print()
+		warm = &summary
 	}
-	if 0 != totalErrors {
-		fmt.Printf("Error Rate: %.2f%%\n", errorRate*100)
+	if err := ctx.Err(); nil != err {
+		fmt.Println("canceled before the bench started")
+		units.end()
+		return Records{}, err
 	}
-	tps := float64(len(r.records.entry)) / (float64(cost) / float64(time.Second))
-	fmt.Printf("TPS: %.2f\n", tps)
 
-	// Calculate the median cost
-	sortedCosts := make([]int64, len(r.records.entry))
-	for i, entry := range r.records.entry {
-		sortedCosts[i] = entry.Cost
+	if nil != r.gate {
+		if err := r.gate(ctx); nil != err {
+			units.end()
+			return Records{}, err
+		}
 	}
-	sort.Slice(sortedCosts, func(i, j int) bool {
-		return sortedCosts[i] < sortedCosts[j]
-	})
-	medianCost := sortedCosts[len(sortedCosts)/2]
-	fmt.Printf("Median Cost: %d, %v\n", medianCost, time.Duration(medianCost))
-	// Calculate the cost at different percentiles
-	percentiles := []float64{0.1, 0.3, 0.7, 0.8, 0.9, 0.99}
-	for _, percentile := range percentiles {
-		index := int(float64(len(sortedCosts)) * percentile)
-		cost := sortedCosts[index]
-		fmt.Printf("Cost at %.2f%%: %d, %v\n", percentile*100, cost, time.Duration(cost))
+	// a sample file that cannot be created fails the bench before it begins
+	var out *sampleWriter
+	if "" != r.Samples.Path {
+		file := r.Samples
+		file.Path = samplePath(file.Path, i, r.Trials)
+		var err error
+		if out, err = createSamples(file); nil != err {
+			units.end()
+			return Records{}, err
+		}
 	}
-
+	fmt.Println("start bench")
+	r.metrics.enter(phaseBench)
+	if err := units.begin(); nil != err {
+		out.discard()
+		units.end()
+		return Records{}, err
+	}
+	// profiles start first, the heap is snapshotted after a gc
+	prof := r.startProfiles(ctx, p, i)
+	r.leaks.reset()
+	begin := r.Clock.Now()
+	params := r.params(p, begin)
+	if nil != out {
+		out.start(params)
+	}
+	var (
+		quiet   = newHalt()
+		sampled chan []HostSample
+		rt      = readRuntime()
+	)
+	if r.HostInterval > 0 {
+		sampled = make(chan []HostSample, 1)
+		go func() {
+			sampled <- r.sampleHost(begin, quiet)
+		}()
+	}
+	// running
+	records := r.benching(ctx, units.handlers(false), p, newTimeline(r.Interval), out)
+	end := r.Clock.Now()
+	if nil != sampled {
+		quiet.stop()
+		records.host = <-sampled
+	}
+	records.runtime = r.runtimeBetween(rt, readRuntime(), records.host)
+	records.profiles = prof.stop()
+	records.leak = r.leaks.stats()
+	records.partial = nil != ctx.Err()
+	records.ops = units.operations()
+	records.clock = r.accuracy()
+	err := units.end()
+	records.workers = units.failures()
+	if nil != out {
+		serr := out.close(SampleInfo{
+			Params:  params,
+			Ops:     records.ops,
+			Wall:    end.Sub(begin),
+			Partial: records.partial,
+			Limited: records.limited,
+			Leaks:   records.leak,
+			Clock:   records.clock,
+		})
+		// the requests were measured all the same
+		if nil != serr {
+			records.samples = fmt.Errorf("%w: %v", ErrSampleWrite, serr)
+		}
+	}
+	if nil != err {
+		return Records{}, err
+	}
+	if records.idle && 0 != len(records.workers) {
+		errs := make([]error, len(records.workers))
+		for i, failure := range records.workers {
+			errs[i] = failure
+		}
+		return Records{}, fmt.Errorf("%w: %w", ErrWorkerSetup, errors.Join(errs...))
+	}
+	records.wall = end.Sub(begin)
+	records.stageWall = stageWindows(p, records.wall)
+	records.params = params
+	records.warm = warm
+	return records, nil
 }
 
-func (r *Runner) benching(ctx context.Context, handler Handler, concurrency int, total int64) {
+// benching runs one phase. Its requests are sliced into intervals when
+// timed is not nil and written to out when that is not.
+func (r *Runner) benching(ctx context.Context, source handlers, p plan, timed *timeline, out *sampleWriter) Records {
 	var (
-		idx int64
-		wg  sync.WaitGroup
+		idx     int64
+		stage   int32
+		stop    = newHalt()
+		drained = newHalt()
+		ticks   chan tick
+		mtx     sync.Mutex
+		merged  = newRecorder(r.Precision, p, nil)
+		started int64
 	)
-	idx = 0
-	wg.Add(concurrency)
-	r.records.entry = make([]RecordEntry, total)
-	for i := 0; i < concurrency; i++ {
-		// code for each iteration
-		go func() {
-			defer wg.Done()
-			for {
-				i := atomic.AddInt64(&idx, 1)
-				if i > total {
-					return
+	atomic.StoreInt32(&r.leaks.limited, 0)
+	// also ends the dispatch of a bench no worker took part in
+	defer stop.stop()
+	defer context.AfterFunc(ctx, stop.stop)()
+	start := r.Clock.Now()
+	if d := p.duration(); 0 != d {
+		timer := time.AfterFunc(d, stop.stop)
+		defer timer.Stop()
+	}
+	if p.open() {
+		ticks = make(chan tick, p.maxConcurrency())
+		go r.dispatch(p, start, stop, ticks)
+	}
+	workers := &crew{
+		empty: drained.stop,
+		work: func(id int, quit *int32) bool {
+			pick, err := source(id)
+			if nil != err {
+				// a worker that could not be set up is not taken up again
+				return false
+			}
+			atomic.AddInt64(&started, 1)
+			r.metrics.working(1)
+			defer r.metrics.working(-1)
+			rec := newRecorder(r.Precision, p, timed)
+			samples := out.buffer(id)
+			for 0 == atomic.LoadInt32(quit) && !stop.stopped() {
+				var (
+					entry RecordEntry
+					// intended is when an open-loop request was due
+					intended time.Time
+				)
+				if nil != ticks {
+					t, ok := <-ticks
+					if !ok {
+						drained.stop()
+						break
+					}
+					if stop.stopped() {
+						break
+					}
+					entry.Stage = t.stage
+					intended = t.at
+				} else {
+					if 0 != p.total && atomic.AddInt64(&idx, 1) > p.total {
+						drained.stop()
+						break
+					}
+					entry.Stage = int(atomic.LoadInt32(&stage))
+				}
+				if !r.throttle(stop) {
+					break
+				}
+				op, handler := pick()
+				entry.Op = op
+				var begin time.Time
+				r.metrics.issuing(1)
+				begin, entry.Cost, entry.Err = r.wrapExec(ctx, handler)
+				r.metrics.issuing(-1)
+				if nil != ticks {
+					// queued and throttled alike, the request waited
+					entry.Wait = begin.Sub(intended).Nanoseconds()
 				}
-				cost, err := r.wrapExec(ctx, handler)
-				r.records.entry[i-1] = RecordEntry{
-					Cost: cost,
-					Err:  err,
+				entry.At = begin.Sub(start).Nanoseconds() + entry.Cost
+				if ErrCanceled == entry.Err {
+					// cut short by cancellation, it never completed
+					break
 				}
+				rec.record(entry)
+				samples.add(entry)
+				r.metrics.observe(entry)
 			}
+			rec.flush()
+			samples.flush()
+			mtx.Lock()
+			merged.merge(rec)
+			mtx.Unlock()
+			return true
+		},
+	}
+	var (
+		quiet   = newHalt()
+		printed chan int
+	)
+	if nil != timed && r.Live {
+		printed = make(chan int, 1)
+		go func() {
+			printed <- timed.watch(start, quiet)
 		}()
 	}
-	wg.Wait()
+	r.steer(p, start, stop, drained, &stage, workers)
+	workers.wait()
+	if nil != printed {
+		// every worker has handed its intervals over, print the rest
+		quiet.stop()
+		timed.printFrom(<-printed, r.Clock.Now().Sub(start))
+	}
+
+	records := merged.records(timed)
+	records.limited = 0 != atomic.LoadInt32(&r.leaks.limited)
+	records.idle = 0 == atomic.LoadInt64(&started)
+	return records
 }
 
+const (
+	execRunning int32 = iota
+	execReturned
+	execAbandoned
+	// execCanceled is a handler left running by the cancel of the bench,
+	// not counted as abandoned
+	execCanceled
+)
+
 var (
-	ErrTimeout = errors.New("timeout")
+	ErrTimeout          = errors.New("timeout")
+	ErrCanceled         = errors.New("canceled")
+	ErrConcurrency      = errors.New("concurrency must be positive")
+	ErrUnbounded        = errors.New("load needs a total or a duration")
+	ErrRate             = errors.New("rate must not be negative")
+	ErrNoStages         = errors.New("profile has no stages")
+	ErrStageDuration    = errors.New("stage duration must be positive")
+	ErrWorkerSetup      = errors.New("worker setup failed")
+	ErrNoOperations     = errors.New("scenario has no operations")
+	ErrOperation        = errors.New("operation needs a name and a handler")
+	ErrWeight           = errors.New("operation weight must be positive")
+	ErrPercentile       = errors.New("percentile must be within (0, 1]")
+	ErrSLO              = errors.New("slo bounds must not be negative")
+	ErrSearchRange      = errors.New("search needs 0 < min <= max")
+	ErrSearchStep       = errors.New("search step must be positive")
+	ErrFormat           = errors.New("unknown report format")
+	ErrPrecision        = errors.New("precision must be within [1, 5]")
+	ErrPercentileMethod = errors.New("unknown percentile method")
+	ErrSamples          = errors.New("malformed sample file")
+	ErrSampleWrite      = errors.New("sample file not written")
+	ErrPprof            = errors.New("unknown pprof profile")
+	ErrUnknownUnit      = errors.New("unknown unit")
+	ErrNoAgents         = errors.New("coordinator has no agents")
+	ErrAgentLost        = errors.New("agent connection lost")
+	ErrManualClock      = errors.New("manual clock cannot drive a bench")
 )
 
-func (r *Runner) wrapExec(ctx context.Context, handler Handler) (cost int64, err error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
+func (r *Runner) wrapExec(parent context.Context, handler ContextHandler) (begin time.Time, cost int64, err error) {
+	ctx := parent
+	if 0 != r.Timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
 	var (
 		done = make(chan error, 1)
+		// running, returned or abandoned, whoever moves it first decides
+		state int32
+		phase = r.leaks.current()
 	)
-	begin := r.Now()
+	begin = r.Clock.Now()
 	go func() {
-		err := handler()
-		select {
-		case done <- err:
-		default:
+		err := handler(ctx)
+		if !atomic.CompareAndSwapInt32(&state, execRunning, execReturned) {
+			if execAbandoned == atomic.LoadInt32(&state) {
+				r.leaks.land(phase)
+			}
+			return
 		}
+		done <- err
 	}()
 	select {
 	case err = <-done:
 	case <-ctx.Done():
+		// only the timeout of the request abandons its handler
+		gone := execAbandoned
+		if nil != parent.Err() {
+			gone = execCanceled
+		}
+		switch {
+		case !atomic.CompareAndSwapInt32(&state, execRunning, gone):
+			err = <-done
+		case execAbandoned == gone:
+			r.leaks.abandon()
+			err = ErrTimeout
+		default:
+			err = ErrCanceled
+		}
+	}
+	switch {
+	case nil == err:
+	case nil != parent.Err():
+		// the whole run was cancelled, not just this request
+		err = ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		// a handler that honours the deadline reports it in its own words
 		err = ErrTimeout
 	}
-	cost = r.Now().Sub(begin).Nanoseconds()
+	cost = r.Clock.Now().Sub(begin).Nanoseconds()
 	return
 }
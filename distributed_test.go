@@ -0,0 +1,150 @@
+package kebench_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+type agentUnit struct {
+	runs *int64
+}
+
+func (u agentUnit) WarmUp(ctx context.Context) error { return nil }
+func (u agentUnit) Begin() error                     { return nil }
+func (u agentUnit) End() error                       { return nil }
+
+func (u agentUnit) Run(ctx context.Context) error {
+	if 0 == atomic.AddInt64(u.runs, 1)%10 {
+		return errors.New("tenth")
+	}
+	time.Sleep(100 * time.Microsecond)
+	return nil
+}
+
+// startAgents serves n agents on localhost until the test ends.
+func startAgents(t *testing.T, n int) []string {
+	var addrs []string
+	for i := 0; i < n; i++ {
+		r := quietRunner(t)
+		addrs = append(addrs, serveAgent(t, kebench.NewAgent(r)))
+	}
+	return addrs
+}
+
+// serveAgent serves agent on localhost until the test ends.
+func serveAgent(t *testing.T, agent *kebench.Agent) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		agent.Serve(ctx, ln)
+	}()
+	return ln.Addr().String()
+}
+
+func TestCoordinator(t *testing.T) {
+	var runs int64
+	kebench.RegisterUnit("agent-test", func(int) (kebench.ContextUnit, error) {
+		return agentUnit{runs: &runs}, nil
+	})
+	agents := startAgents(t, 3)
+	r := quietRunner(t)
+	r.WarmUp = kebench.WarmUp{Total: 30}
+	r.Interval = 50 * time.Millisecond
+	c := kebench.NewCoordinator(r, agents...)
+
+	rep, err := c.Bench(context.Background(), "agent-test", kebench.Load{Concurrency: 6, Total: 3001})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3001 != rep.Requests || 3001 != atomic.LoadInt64(&runs) {
+		t.Errorf("requests %d, runs %d", rep.Requests, runs)
+	}
+	if rep.Errors < 250 || rep.Errors > 350 || rep.Errors != rep.ErrorTypes["tenth"] {
+		t.Errorf("errors %d %v", rep.Errors, rep.ErrorTypes)
+	}
+	var inIntervals int64
+	for _, in := range rep.Intervals {
+		inIntervals += in.Requests
+	}
+	if inIntervals != rep.Requests || rep.Distribution.Count() != rep.Requests {
+		t.Errorf("intervals count %d, distribution %d of %d", inIntervals, rep.Distribution.Count(), rep.Requests)
+	}
+
+	rep, err = c.Bench(context.Background(), "agent-test", kebench.Load{Concurrency: 3, Duration: 200 * time.Millisecond})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 == rep.Requests || rep.Wall < 200*time.Millisecond || rep.Wall > time.Second {
+		t.Errorf("timed bench %d requests in %v", rep.Requests, rep.Wall)
+	}
+
+	// fewer requests than agents
+	atomic.StoreInt64(&runs, 0)
+	if rep, err = c.Bench(context.Background(), "agent-test", kebench.Load{Concurrency: 3, Total: 2}); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != rep.Requests || 2 != atomic.LoadInt64(&runs) {
+		t.Errorf("total 2 over 3 agents: requests %d, runs %d", rep.Requests, runs)
+	}
+
+	if _, err := c.Bench(context.Background(), "missing", kebench.Load{Concurrency: 3, Total: 10}); nil == err || !strings.Contains(err.Error(), kebench.ErrUnknownUnit.Error()) {
+		t.Errorf("unknown unit: %v", err)
+	}
+}
+
+func TestAgentRunnerKept(t *testing.T) {
+	kebench.RegisterUnit("agent-kept", func(int) (kebench.ContextUnit, error) {
+		return agentUnit{runs: new(int64)}, nil
+	})
+	r := quietRunner(t)
+	c := kebench.NewCoordinator(quietRunner(t), serveAgent(t, kebench.NewAgent(r)))
+	c.Runner.WarmUp = kebench.WarmUp{Total: 5}
+	c.Runner.Interval = 10 * time.Millisecond
+	c.Runner.Timeout = 0
+	c.Runner.Precision = 2
+	for i := 0; i < 2; i++ {
+		if _, err := c.Bench(context.Background(), "agent-kept", kebench.Load{Concurrency: 1, Total: 10}); nil != err {
+			t.Fatal(err)
+		}
+	}
+	want := quietRunner(t)
+	if r.WarmUp != want.WarmUp || r.Interval != want.Interval || r.Timeout != want.Timeout || r.Precision != want.Precision {
+		t.Errorf("agent runner took the job's settings: warm-up %+v, interval %v, timeout %v, precision %d",
+			r.WarmUp, r.Interval, r.Timeout, r.Precision)
+	}
+}
+
+func TestCoordinatorCancel(t *testing.T) {
+	kebench.RegisterUnit("agent-slow", func(int) (kebench.ContextUnit, error) {
+		return agentUnit{runs: new(int64)}, nil
+	})
+	r := quietRunner(t)
+	c := kebench.NewCoordinator(r, startAgents(t, 2)...)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	rep, err := c.Bench(ctx, "agent-slow", kebench.Load{Concurrency: 2, Duration: 10 * time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v, want deadline exceeded", err)
+	}
+	if nil == rep || !rep.Partial || 0 == rep.Requests || rep.Wall > 2*time.Second {
+		t.Errorf("partial report %+v", rep)
+	}
+}
@@ -7,6 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -18,6 +23,37 @@ var (
 	addr        string
 	concurrency int
 	total       int
+	duration    time.Duration
+	rate        float64
+	poisson     bool
+	profile     string
+	timeout     time.Duration
+	abandoned   int
+	pooled      bool
+	searchMax   float64
+	searchStep  time.Duration
+	sloP        float64
+	sloLatency  time.Duration
+	sloErrors   float64
+	outputs     []kebench.Output
+	precision   int
+	interval    time.Duration
+	live        bool
+	trials      int
+	percentiles []float64
+	method      kebench.PercentileMethod
+	cooldown    time.Duration
+	samples     kebench.SampleFile
+	metrics     string
+	hostEvery   time.Duration
+	profiles    kebench.Pprof
+	clockTick   time.Duration
+	agentAddr   string
+	agents      []string
+	warmTotal   int
+	warmDur     time.Duration
+	warmConc    int
+	warmStable  float64
 	bodySize    int
 	ctype       int
 )
@@ -26,6 +62,69 @@ func initFlag() {
 	flag.StringVar(&addr, "s", ":9999", "server address")
 	flag.IntVar(&concurrency, "c", 1, "concurrency")
 	flag.IntVar(&total, "n", 1, "total")
+	flag.DurationVar(&duration, "d", 0, "duration, overrides total when set")
+	flag.Float64Var(&rate, "r", 0, "open-loop request rate per second, 0 runs closed-loop")
+	flag.BoolVar(&poisson, "poisson", false, "poisson inter-arrival times for open-loop runs")
+	flag.DurationVar(&timeout, "timeout", time.Second, "per-request timeout, 0 disables it")
+	flag.IntVar(&abandoned, "abandoned", kebench.DefaultMaxAbandoned, "cap on handlers left running by timeouts, 0 disables it")
+	flag.IntVar(&warmTotal, "wn", int(kebench.DefaultWarmUp.Total), "warm-up total, 0 with -wd 0 skips the warm-up")
+	flag.DurationVar(&warmDur, "wd", kebench.DefaultWarmUp.Duration, "warm-up duration")
+	flag.IntVar(&warmConc, "wc", 0, "warm-up concurrency, 0 uses the bench concurrency")
+	flag.Float64Var(&warmStable, "wstable", 0, "warm up until the latency cv drops below this, 0 disables it")
+	flag.BoolVar(&pooled, "pool", false, "share pooled connections between workers instead of one per worker")
+	flag.Float64Var(&searchMax, "search", 0, "search the highest concurrency, or rate with -r, up to this that keeps the slo")
+	flag.DurationVar(&searchStep, "step", 5*time.Second, "how long each search probe runs")
+	flag.Float64Var(&sloP, "slo-p", 0.99, "slo percentile")
+	flag.DurationVar(&sloLatency, "slo-lat", 5*time.Millisecond, "slo latency bound of the percentile")
+	flag.Float64Var(&sloErrors, "slo-err", 0.001, "slo error rate bound, 0 allows no errors")
+	flag.Func("o", "extra report output as format=path, formats text, json, csv, md and html, repeatable", func(spec string) error {
+		output, err := kebench.ParseOutput(spec)
+		if nil != err {
+			return err
+		}
+		outputs = append(outputs, output)
+		return nil
+	})
+	flag.IntVar(&precision, "precision", kebench.DefaultPrecision, "significant digits the latency histograms keep, 1 to 5")
+	flag.DurationVar(&interval, "i", kebench.DefaultInterval, "interval of the time series in the report")
+	flag.BoolVar(&live, "live", true, "print the stats of every interval while the bench runs")
+	flag.IntVar(&trials, "trials", 1, "repeat the warm-up and bench this many times and report confidence intervals")
+	flag.DurationVar(&cooldown, "cooldown", 0, "pause between trials")
+	flag.StringVar(&samples.Path, "samples", "", "write every request to this sample file")
+	flag.BoolVar(&samples.Compress, "samples-gz", false, "gzip the sample file")
+	flag.DurationVar(&hostEvery, "host", kebench.DefaultHostInterval, "how often to sample host cpu, memory, load and network, 0 disables it")
+	flag.Func("pprof", "comma separated pprof profiles to capture during the bench: cpu, heap, mutex, block, goroutine or all", func(list string) error {
+		profiles.Kinds = strings.Split(list, ",")
+		if "all" == list {
+			profiles.Kinds = kebench.PprofKinds
+		}
+		return nil
+	})
+	flag.StringVar(&profiles.Target, "pprof-target", "", "also pull the profiles from this pprof url, e.g. http://localhost:6060/debug/pprof")
+	flag.StringVar(&profiles.Path, "pprof-path", "", "prefix of the profile files, by default that of the first -o file")
+	flag.DurationVar(&clockTick, "clock", 0, "time requests with a cached clock updated this often, 0 reads the system clock")
+	flag.StringVar(&agentAddr, "agent", "", "serve as an agent on this address, running the benches a coordinator asks for")
+	flag.Func("agents", "comma separated agent addresses to coordinate instead of benching from here", func(list string) error {
+		agents = strings.Split(list, ",")
+		return nil
+	})
+	flag.StringVar(&metrics, "metrics", "", "serve prometheus metrics at /metrics on this address while the bench runs, e.g. :9100")
+	flag.Func("q", "comma separated report percentiles, e.g. 0.5,0.99,0.999", func(list string) error {
+		percentiles = nil
+		for _, field := range strings.Split(list, ",") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if nil != err {
+				return err
+			}
+			percentiles = append(percentiles, q)
+		}
+		return nil
+	})
+	flag.Func("pm", "percentile method, nearest-rank or interpolated", func(name string) (err error) {
+		method, err = kebench.ParsePercentileMethod(name)
+		return
+	})
+	flag.StringVar(&profile, "p", "", "staged profile, comma separated duration:concurrency[:rate][:ramp], e.g. 60s:200:ramp,5m:200,10s:1000")
 	flag.IntVar(&bodySize, "b", 1024, "body size")
 	flag.IntVar(&ctype, "t", 1, "codec type")
 }
@@ -39,7 +138,14 @@ var (
 	create func(io.ReadWriter) netstd.Codec
 )
 
-func (c *clientUnit) WarmUp() error {
+// setDeadline carries the request deadline over to conn, pooled
+// connections without one get their previous deadline cleared.
+func setDeadline(ctx context.Context, conn net.Conn) error {
+	deadline, _ := ctx.Deadline()
+	return conn.SetDeadline(deadline)
+}
+
+func (c *clientUnit) WarmUp(ctx context.Context) error {
 	conn, ok := c.pool.Get()
 	if !ok {
 		return noconn
@@ -49,6 +155,9 @@ func (c *clientUnit) WarmUp() error {
 	defer func() {
 		c.pool.Push(conn, err)
 	}()
+	if err = setDeadline(ctx, conn); nil != err {
+		return err
+	}
 
 	codec := netstd.NewEchoCodec(conn)
 	err = codec.Encode(&kebench.BenchMessage{})
@@ -60,7 +169,7 @@ func (c *clientUnit) WarmUp() error {
 	return err
 }
 
-func (c *clientUnit) Run() error {
+func (c *clientUnit) Run(ctx context.Context) error {
 	conn, ok := c.pool.Get()
 	if !ok {
 		return noconn
@@ -70,6 +179,9 @@ func (c *clientUnit) Run() error {
 	defer func() {
 		c.pool.Push(conn, err)
 	}()
+	if err = setDeadline(ctx, conn); nil != err {
+		return err
+	}
 
 	codec := netstd.NewEchoCodec(conn)
 	body := make([]byte, bodySize)
@@ -93,6 +205,9 @@ func (c *clientUnit) Begin() error {
 	defer func() {
 		c.pool.Push(conn, err)
 	}()
+	if err = setDeadline(context.Background(), conn); nil != err {
+		return err
+	}
 	codec := netstd.NewEchoCodec(conn)
 	err = codec.Encode(&kebench.BenchMessage{})
 	if nil != err {
@@ -113,6 +228,9 @@ func (c *clientUnit) End() error {
 	defer func() {
 		c.pool.Push(conn, err)
 	}()
+	if err = setDeadline(context.Background(), conn); nil != err {
+		return err
+	}
 
 	codec := netstd.NewEchoCodec(conn)
 	err = codec.Encode(&kebench.BenchMessage{})
@@ -129,7 +247,7 @@ func main() {
 	flag.Parse()
 
 	fmt.Println("server address:", addr, "concurrency:", concurrency,
-		"total:", total, "body size:", bodySize, "codec type:", ctype)
+		"total:", total, "duration:", duration, "rate:", rate, "body size:", bodySize, "codec type:", ctype)
 
 	switch ctype {
 	case 1:
@@ -146,21 +264,148 @@ func main() {
 	}
 
 	runner := kebench.NewRunner(time.Now)
-
-	unit := new(clientUnit)
-	atleast := concurrency
-	if atleast < 1024 {
-		atleast = 1024
+	runner.Timeout = timeout
+	runner.MaxAbandoned = abandoned
+	runner.Outputs = append(runner.Outputs, outputs...)
+	runner.Precision = precision
+	runner.Interval = interval
+	runner.Live = live
+	runner.Trials = trials
+	runner.PercentileMethod = method
+	if nil != percentiles {
+		runner.Percentiles = percentiles
 	}
-	unit.pool = kebench.NewConnectionPool[net.Conn](func() (net.Conn, bool) {
-		conn, err := net.Dial("tcp", addr)
+	runner.Cooldown = cooldown
+	runner.Samples = samples
+	runner.Metrics = metrics
+	runner.HostInterval = hostEvery
+	runner.Pprof = profiles
+	if 0 != clockTick {
+		clock := kebench.NewCachedClock(clockTick)
+		defer clock.Stop()
+		runner.Clock = clock
+	}
+	runner.WarmUp = kebench.WarmUp{
+		Concurrency: warmConc,
+		Total:       int64(warmTotal),
+		Duration:    warmDur,
+		Stable:      warmStable,
+	}
+
+	var schedule kebench.Schedule
+	if "" != profile {
+		stages, err := parseStages(profile)
 		if nil != err {
-			return nil, false
+			fmt.Println("invalid profile", err)
+			return
+		}
+		prof := kebench.Profile{Stages: stages}
+		if poisson {
+			prof.Arrival = kebench.ArrivalPoisson
+		}
+		schedule = prof
+	} else {
+		load := kebench.Load{
+			Concurrency: concurrency,
+			Total:       int64(total),
+			Rate:        rate,
 		}
-		return conn, true
-	}, func(conn net.Conn) {
-		conn.Close()
-	}, atleast)
+		if 0 != duration {
+			load.Total = 0
+			load.Duration = duration
+		}
+		if poisson {
+			load.Arrival = kebench.ArrivalPoisson
+		}
+		schedule = load
+	}
+
+	// Ctrl-C stops the workers and still prints what completed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	kebench.RegisterUnit("net-std", newWorkerUnit)
+	if "" != agentAddr {
+		fmt.Println("agent listening on", agentAddr)
+		err = kebench.NewAgent(runner).ListenAndServe(ctx, agentAddr)
+	} else if 0 != len(agents) {
+		_, err = kebench.NewCoordinator(runner, agents...).Bench(ctx, "net-std", schedule)
+	} else if 0 != searchMax {
+		search := kebench.Search{
+			SLO:         kebench.SLO{Percentile: sloP, Latency: sloLatency, ErrorRate: sloErrors, NoErrors: 0 == sloErrors},
+			Min:         float64(concurrency),
+			Max:         searchMax,
+			Step:        searchStep,
+			Precision:   0.05,
+			Concurrency: concurrency,
+		}
+		if 0 != rate {
+			search.Rate = true
+			search.Min = rate
+		}
+		if poisson {
+			search.Arrival = kebench.ArrivalPoisson
+		}
+		_, err = runner.SearchUnits(ctx, newWorkerUnit, search)
+	} else if pooled {
+		unit := new(clientUnit)
+		atleast := concurrency
+		if atleast < 1024 {
+			atleast = 1024
+		}
+		unit.pool = kebench.NewConnectionPool[net.Conn](func() (net.Conn, bool) {
+			conn, err := net.Dial("tcp", addr)
+			if nil != err {
+				return nil, false
+			}
+			return conn, true
+		}, func(conn net.Conn) {
+			conn.Close()
+		}, atleast)
+		_, err = runner.Bench(ctx, unit, schedule)
+	} else {
+		_, err = runner.BenchUnits(ctx, newWorkerUnit, schedule)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("run canceled")
+	} else if nil != err {
+		fmt.Println("run failed", err)
+	}
+}
 
-	runner.Run(context.Background(), unit, concurrency, int64(total))
+// parseStages reads a profile written as duration:concurrency[:rate][:ramp]
+// stages separated by commas.
+func parseStages(in string) ([]kebench.Stage, error) {
+	var stages []kebench.Stage
+	for _, part := range strings.Split(in, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("stage %q needs duration:concurrency", part)
+		}
+		var (
+			stage kebench.Stage
+			err   error
+		)
+		stage.Duration, err = time.ParseDuration(fields[0])
+		if nil != err {
+			return nil, err
+		}
+		stage.Concurrency, err = strconv.Atoi(fields[1])
+		if nil != err {
+			return nil, err
+		}
+		for _, field := range fields[2:] {
+			if "ramp" == field {
+				stage.Ramp = true
+				continue
+			}
+			stage.Rate, err = strconv.ParseFloat(field, 64)
+			if nil != err {
+				return nil, err
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"unsafe"
+
+	kebench "github.com/jsn4ke/ke_bench"
+	netstd "github.com/jsn4ke/ke_bench/example/net-std"
+)
+
+// workerUnit is what one virtual user owns: a connection, its codec and
+// the request body.
+type workerUnit struct {
+	// mtx keeps a request from starting while an abandoned one still
+	// holds the connection, which its deadline releases shortly
+	mtx   sync.Mutex
+	conn  net.Conn
+	codec netstd.Codec
+	body  string
+}
+
+func newWorkerUnit(int) (kebench.ContextUnit, error) {
+	body := make([]byte, bodySize)
+	w := &workerUnit{body: unsafe.String(&body[0], len(body))}
+	return w, w.dial()
+}
+
+func (w *workerUnit) dial() error {
+	conn, err := net.Dial("tcp", addr)
+	if nil != err {
+		return err
+	}
+	w.conn = conn
+	w.codec = create(conn)
+	return nil
+}
+
+func (w *workerUnit) exchange(ctx context.Context, msg *kebench.BenchMessage) (err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if nil == w.conn {
+		if err = w.dial(); nil != err {
+			return err
+		}
+	}
+	defer func() {
+		// the stream is out of step after any failure, start over
+		if nil != err {
+			w.conn.Close()
+			w.conn = nil
+		}
+	}()
+	if err = setDeadline(ctx, w.conn); nil != err {
+		return err
+	}
+	if err = w.codec.Encode(msg); nil != err {
+		return err
+	}
+	_, err = w.codec.Decode()
+	return err
+}
+
+func (w *workerUnit) WarmUp(ctx context.Context) error {
+	return w.exchange(ctx, &kebench.BenchMessage{})
+}
+
+func (w *workerUnit) Run(ctx context.Context) error {
+	return w.exchange(ctx, &kebench.BenchMessage{Msg: w.body})
+}
+
+func (w *workerUnit) Begin() error {
+	return nil
+}
+
+func (w *workerUnit) End() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if nil == w.conn {
+		return nil
+	}
+	return w.conn.Close()
+}
@@ -2,7 +2,10 @@ module github.com/jsn4ke/ke_bench
 
 go 1.21.5
 
-require github.com/shirou/gopsutil/v3 v3.24.2
+require (
+	github.com/google/pprof v0.0.0-20240409012703-83162a5b38cd
+	github.com/shirou/gopsutil/v3 v3.24.2
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -0,0 +1,315 @@
+package kebench
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// DefaultPrecision is how many significant digits the histograms of a new
+// Runner keep.
+const DefaultPrecision = 3
+
+// Histogram counts values, latencies in nanoseconds, in log-linear buckets
+// in the manner of HdrHistogram: values below 2^b are counted exactly and
+// every power of two above is split into 2^(b-1) buckets of equal width,
+// b chosen so a value is known to its precision in significant digits.
+// Its memory depends on the range of the values it saw, not their number,
+// and two histograms merge without losing anything.
+//
+// A Histogram is not safe for concurrent use, workers record into their
+// own and merge them once they are done.
+type Histogram struct {
+	bits int
+	// lo is the bucket index of counts[0], counts only spans the
+	// buckets seen so far
+	lo       int
+	counts   []int64
+	n        int64
+	sum      int64
+	min, max int64
+	// running mean and sum of squared deviations, Welford's method
+	mean, m2 float64
+}
+
+// NewHistogram returns an empty histogram keeping precision significant
+// digits, clamped to [1, 5].
+func NewHistogram(precision int) *Histogram {
+	precision = min(max(precision, 1), 5)
+	return &Histogram{bits: bits.Len64(2*uint64(math.Pow10(precision)) - 1)}
+}
+
+func validPrecision(precision int) bool {
+	return precision >= 1 && precision <= 5
+}
+
+func (h *Histogram) index(v int64) int {
+	u := uint64(max(v, 0))
+	if u < 1<<h.bits {
+		return int(u)
+	}
+	shift := bits.Len64(u) - h.bits
+	return shift<<(h.bits-1) + int(u>>shift)
+}
+
+// upper is the largest value counted in bucket i.
+func (h *Histogram) upper(i int) int64 {
+	if i < 1<<h.bits {
+		return int64(i)
+	}
+	shift := i>>(h.bits-1) - 1
+	m := uint64(i - shift<<(h.bits-1))
+	u := (m+1)<<shift - 1
+	if u > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(u)
+}
+
+// Record counts v, negative values as zero.
+func (h *Histogram) Record(v int64) {
+	v = max(v, 0)
+	h.add(h.index(v), 1)
+	h.n++
+	h.sum += v
+	if 1 == h.n || v < h.min {
+		h.min = v
+	}
+	if 1 == h.n || v > h.max {
+		h.max = v
+	}
+	delta := float64(v) - h.mean
+	h.mean += delta / float64(h.n)
+	h.m2 += delta * (float64(v) - h.mean)
+}
+
+// recordN counts v n times.
+func (h *Histogram) recordN(v, n int64) {
+	if n <= 0 {
+		return
+	}
+	v = max(v, 0)
+	h.Merge(&Histogram{
+		bits:   h.bits,
+		lo:     h.index(v),
+		counts: []int64{n},
+		n:      n,
+		sum:    v * n,
+		min:    v,
+		max:    v,
+		mean:   float64(v),
+	})
+}
+
+// add counts n in bucket i, growing counts to span it.
+func (h *Histogram) add(i int, n int64) {
+	switch {
+	case nil == h.counts:
+		h.lo = i
+		h.counts = make([]int64, 1, 64)
+	case i < h.lo:
+		counts := make([]int64, h.lo-i+len(h.counts), h.lo-i+cap(h.counts))
+		copy(counts[h.lo-i:], h.counts)
+		h.lo, h.counts = i, counts
+	case i >= h.lo+len(h.counts):
+		h.counts = append(h.counts, make([]int64, i-h.lo-len(h.counts)+1)...)
+	}
+	h.counts[i-h.lo] += n
+}
+
+// Merge adds the values of o. Buckets of an o of another precision are
+// recorded at their upper bound.
+func (h *Histogram) Merge(o *Histogram) {
+	if nil == o || 0 == o.n {
+		return
+	}
+	for j, c := range o.counts {
+		if 0 == c {
+			continue
+		}
+		i := o.lo + j
+		if o.bits != h.bits {
+			i = h.index(o.upper(i))
+		}
+		h.add(i, c)
+	}
+	if 0 == h.n || o.min < h.min {
+		h.min = o.min
+	}
+	if 0 == h.n || o.max > h.max {
+		h.max = o.max
+	}
+	// Chan et al.'s parallel variant of Welford's method
+	n := h.n + o.n
+	delta := o.mean - h.mean
+	h.m2 += o.m2 + delta*delta*float64(h.n)*float64(o.n)/float64(n)
+	h.mean += delta * float64(o.n) / float64(n)
+	h.n = n
+	h.sum += o.sum
+}
+
+// Reset empties h, keeping its buckets allocated.
+func (h *Histogram) Reset() {
+	clear(h.counts)
+	h.n, h.sum, h.min, h.max, h.mean, h.m2 = 0, 0, 0, 0, 0, 0
+}
+
+func (h *Histogram) Count() int64 { return h.n }
+func (h *Histogram) Sum() int64   { return h.sum }
+func (h *Histogram) Min() int64   { return h.min }
+func (h *Histogram) Max() int64   { return h.max }
+
+// Mean is the exact mean of the values recorded.
+func (h *Histogram) Mean() float64 { return h.mean }
+
+// StdDev is the exact population standard deviation of the values.
+func (h *Histogram) StdDev() float64 {
+	if 0 == h.n {
+		return 0
+	}
+	return math.Sqrt(h.m2 / float64(h.n))
+}
+
+// ValueAt is the q-th quantile of the values by the nearest-rank method,
+// the smallest value at least the fraction q of the values do not exceed.
+// It is known within the precision of h and never outside [Min, Max].
+func (h *Histogram) ValueAt(q float64) int64 {
+	if 0 == h.n {
+		return 0
+	}
+	// the epsilon keeps 0.07*100 from ranking 8th
+	return h.valueAtRank(int64(math.Ceil(q*float64(h.n) - 1e-9)))
+}
+
+// Quantile is the q-th quantile of the values by method.
+func (h *Histogram) Quantile(q float64, method PercentileMethod) float64 {
+	if Interpolated != method || 0 == h.n {
+		return float64(h.ValueAt(q))
+	}
+	// linear between the closest ranks, numbered from 0
+	x := q * float64(h.n-1)
+	lo := int64(x)
+	a, b := h.valueAtRank(lo+1), h.valueAtRank(lo+2)
+	return float64(a) + (x-float64(lo))*float64(b-a)
+}
+
+// valueAtRank is the rank-th smallest value, counted from 1 and clamped
+// to the values there are.
+func (h *Histogram) valueAtRank(rank int64) int64 {
+	rank = min(max(rank, 1), h.n)
+	var seen int64
+	for j, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(max(h.upper(h.lo+j), h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// stats summarises h at percentiles. Without values every statistic is
+// zero, the percentiles included.
+func (h *Histogram) stats(percentiles []float64, method PercentileMethod) Stats {
+	if nil == h || 0 == h.n {
+		stats := Stats{Percentiles: make([]Quantile, len(percentiles))}
+		for i, q := range percentiles {
+			stats.Percentiles[i].Q = q
+		}
+		return stats
+	}
+	stats := Stats{
+		Count:       h.n,
+		Min:         time.Duration(h.min),
+		Max:         time.Duration(h.max),
+		Mean:        time.Duration(h.mean),
+		StdDev:      time.Duration(h.StdDev()),
+		Sum:         time.Duration(h.sum),
+		Percentiles: make([]Quantile, len(percentiles)),
+	}
+	for i, q := range percentiles {
+		stats.Percentiles[i] = Quantile{Q: q, Value: time.Duration(h.Quantile(q, method))}
+	}
+	return stats
+}
+
+// rebin counts h into n log-spaced buckets between Min and Max.
+func (h *Histogram) rebin(n int) []Bucket {
+	if nil == h || 0 == h.n {
+		return nil
+	}
+	lo, hi := max(h.min, 1), h.max
+	if hi <= lo {
+		return []Bucket{{Upper: time.Duration(hi), Count: h.n}}
+	}
+	growth := math.Pow(float64(hi)/float64(lo), 1/float64(n))
+	buckets := make([]Bucket, n)
+	for b := range buckets {
+		buckets[b].Upper = time.Duration(float64(lo) * math.Pow(growth, float64(b+1)))
+	}
+	buckets[n-1].Upper = time.Duration(hi)
+	var b int
+	h.each(func(upper, count int64) {
+		for b < n-1 && time.Duration(upper) > buckets[b].Upper {
+			b++
+		}
+		buckets[b].Count += count
+	})
+	return buckets
+}
+
+// each calls fn with the upper bound and count of every bucket holding
+// values, in ascending order.
+func (h *Histogram) each(fn func(upper, count int64)) {
+	for j, c := range h.counts {
+		if 0 != c {
+			fn(min(max(h.upper(h.lo+j), h.min), h.max), c)
+		}
+	}
+}
+
+// rank is how many values are at most v, within the precision of h.
+func (h *Histogram) rank(v int64) int64 {
+	var n int64
+	h.each(func(upper, count int64) {
+		if upper <= v {
+			n += count
+		}
+	})
+	return n
+}
+
+type histogramJSON struct {
+	Bits   int
+	Lo     int
+	Counts []int64
+	Count  int64
+	Sum    int64
+	Min    int64
+	Max    int64
+	Mean   float64
+	M2     float64
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(histogramJSON{
+		Bits: h.bits, Lo: h.lo, Counts: h.counts,
+		Count: h.n, Sum: h.sum, Min: h.min, Max: h.max, Mean: h.mean, M2: h.m2,
+	})
+}
+
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var v histogramJSON
+	if err := json.Unmarshal(data, &v); nil != err {
+		return err
+	}
+	if v.Bits < 2 || v.Bits > 24 {
+		return fmt.Errorf("histogram with %d bits", v.Bits)
+	}
+	*h = Histogram{
+		bits: v.Bits, lo: v.Lo, counts: v.Counts,
+		n: v.Count, sum: v.Sum, min: v.Min, max: v.Max, mean: v.Mean, m2: v.M2,
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package kebench_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func TestHistogramPrecision(t *testing.T) {
+	for _, precision := range []int{1, 2, 3} {
+		h := kebench.NewHistogram(precision)
+		values := make([]int64, 10000)
+		for i := range values {
+			values[i] = rand.Int63n(int64(1e9))
+			h.Record(values[i])
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		if h.Min() != values[0] || h.Max() != values[len(values)-1] {
+			t.Errorf("precision %d: min %d max %d, want %d %d", precision, h.Min(), h.Max(), values[0], values[len(values)-1])
+		}
+		bound := 1.0
+		for i := 0; i < precision; i++ {
+			bound /= 10
+		}
+		for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+			want := values[int(math.Ceil(float64(len(values))*q))-1]
+			got := h.ValueAt(q)
+			if err := float64(got-want) / float64(want); err < 0 || err > bound {
+				t.Errorf("precision %d: p%g %d, want %d within %g", precision, q*100, got, want, bound)
+			}
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	all, a, b := kebench.NewHistogram(3), kebench.NewHistogram(3), kebench.NewHistogram(3)
+	for i := int64(1); i <= 5000; i++ {
+		v := i * i
+		all.Record(v)
+		if 0 == i%3 {
+			a.Record(v)
+		} else {
+			b.Record(v)
+		}
+	}
+	a.Merge(b)
+	if a.Count() != all.Count() || a.Sum() != all.Sum() || a.Min() != all.Min() || a.Max() != all.Max() {
+		t.Fatalf("merged %d %d [%d, %d], want %d %d [%d, %d]",
+			a.Count(), a.Sum(), a.Min(), a.Max(), all.Count(), all.Sum(), all.Min(), all.Max())
+	}
+	for _, q := range []float64{0.1, 0.5, 0.99} {
+		if a.ValueAt(q) != all.ValueAt(q) {
+			t.Errorf("p%g %d, want %d", q*100, a.ValueAt(q), all.ValueAt(q))
+		}
+	}
+	if d := a.StdDev() - all.StdDev(); d > 1e-6*all.StdDev() || d < -1e-6*all.StdDev() {
+		t.Errorf("stddev %f, want %f", a.StdDev(), all.StdDev())
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := kebench.NewHistogram(3)
+	for _, v := range []int64{40, 10, 30, 20} {
+		h.Record(v)
+	}
+	for _, c := range []struct {
+		q            float64
+		nearest, lin float64
+	}{
+		{0.25, 10, 17.5},
+		{0.5, 20, 25},
+		{0.75, 30, 32.5},
+		{1, 40, 40},
+	} {
+		if v := h.Quantile(c.q, kebench.NearestRank); v != c.nearest {
+			t.Errorf("nearest-rank p%g %g, want %g", c.q*100, v, c.nearest)
+		}
+		if v := h.Quantile(c.q, kebench.Interpolated); v != c.lin {
+			t.Errorf("interpolated p%g %g, want %g", c.q*100, v, c.lin)
+		}
+	}
+	if v := kebench.NewHistogram(3).Quantile(0.5, kebench.Interpolated); 0 != v {
+		t.Errorf("empty p50 %g", v)
+	}
+}
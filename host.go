@@ -0,0 +1,212 @@
+package kebench
+
+import (
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// DefaultHostInterval is how often a new Runner samples the host.
+const DefaultHostInterval = time.Second
+
+// HostSample is the state of the host over the Window ending at Offset
+// from the start of the bench. CPU and Cores are busy percentages, Memory
+// the share of memory in use, ContextSwitches and the network bytes rates
+// per second. What the platform does not tell stays zero. Goroutines are
+// those of this process.
+type HostSample struct {
+	Offset          time.Duration
+	Window          time.Duration
+	CPU             float64
+	Cores           []float64
+	Memory          float64
+	MemoryUsed      uint64
+	Load1           float64
+	ContextSwitches float64
+	NetSent         float64
+	NetRecv         float64
+	Goroutines      int
+}
+
+// Gauge is the range of a sampled value.
+type Gauge struct {
+	Min  float64
+	Mean float64
+	Max  float64
+}
+
+// HostReport is the load on the host while the bench ran, over Samples
+// samples. The samples themselves are in the Host of each interval.
+type HostReport struct {
+	Samples         int
+	CPU             Gauge
+	Cores           []Gauge
+	Memory          Gauge
+	Load1           Gauge
+	ContextSwitches Gauge
+	NetSent         Gauge
+	NetRecv         Gauge
+}
+
+// hostReading is what the host counters read at a time.
+type hostReading struct {
+	at    time.Time
+	cpu   []cpu.TimesStat
+	cores []cpu.TimesStat
+	ctxt  int
+	sent  uint64
+	recv  uint64
+	// hasCtxt and hasNet are set when those counters could be read
+	hasCtxt bool
+	hasNet  bool
+}
+
+func readHost(at time.Time) hostReading {
+	h := hostReading{at: at}
+	h.cpu, _ = cpu.Times(false)
+	h.cores, _ = cpu.Times(true)
+	if misc, err := load.Misc(); nil == err {
+		h.ctxt, h.hasCtxt = misc.Ctxt, true
+	}
+	if io, err := net.IOCounters(false); nil == err && 0 != len(io) {
+		h.sent, h.recv, h.hasNet = io[0].BytesSent, io[0].BytesRecv, true
+	}
+	return h
+}
+
+// busy is the busy percentage of a cpu between two readings.
+func busy(a, b cpu.TimesStat) float64 {
+	total := func(t cpu.TimesStat) (all, idle float64) {
+		idle = t.Idle + t.Iowait
+		return t.User + t.System + t.Nice + t.Irq + t.Softirq + t.Steal + idle, idle
+	}
+	allA, idleA := total(a)
+	allB, idleB := total(b)
+	if allB <= allA {
+		return 0
+	}
+	return math.Min(100, math.Max(0, 100*(1-(idleB-idleA)/(allB-allA))))
+}
+
+// sample is the host between prev and h, begin being the start of the
+// bench.
+func (h hostReading) sample(prev hostReading, begin time.Time) HostSample {
+	s := HostSample{Offset: h.at.Sub(begin), Window: h.at.Sub(prev.at), Goroutines: runtime.NumGoroutine()}
+	if 1 == len(h.cpu) && 1 == len(prev.cpu) {
+		s.CPU = busy(prev.cpu[0], h.cpu[0])
+	}
+	if len(h.cores) == len(prev.cores) {
+		for i := range h.cores {
+			s.Cores = append(s.Cores, busy(prev.cores[i], h.cores[i]))
+		}
+	}
+	if vm, err := mem.VirtualMemory(); nil == err {
+		s.Memory, s.MemoryUsed = vm.UsedPercent, vm.Used
+	}
+	if avg, err := load.Avg(); nil == err {
+		s.Load1 = avg.Load1
+	}
+	// a counter that failed to read on either side, or went back, gives
+	// no rate rather than a wrapped or since-boot one
+	if seconds := s.Window.Seconds(); seconds > 0 {
+		if h.hasCtxt && prev.hasCtxt && h.ctxt >= prev.ctxt {
+			s.ContextSwitches = float64(h.ctxt-prev.ctxt) / seconds
+		}
+		if h.hasNet && prev.hasNet && h.sent >= prev.sent && h.recv >= prev.recv {
+			s.NetSent = float64(h.sent-prev.sent) / seconds
+			s.NetRecv = float64(h.recv-prev.recv) / seconds
+		}
+	}
+	return s
+}
+
+// sampleHost samples the host every HostInterval until quit, and once more
+// for the time since the last sample. It is started right after the units
+// begin and stopped before they end.
+func (r *Runner) sampleHost(begin time.Time, quit *halt) []HostSample {
+	var samples []HostSample
+	prev := readHost(begin)
+	ticker := time.NewTicker(r.HostInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit.done:
+			if now := r.Clock.Now(); now.Sub(prev.at) >= r.HostInterval/10 {
+				samples = append(samples, readHost(now).sample(prev, begin))
+			}
+			return samples
+		case <-ticker.C:
+		}
+		h := readHost(r.Clock.Now())
+		samples = append(samples, h.sample(prev, begin))
+		prev = h
+	}
+}
+
+func gaugeOf(samples []HostSample, value func(HostSample) float64) Gauge {
+	g := Gauge{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, s := range samples {
+		v := value(s)
+		g.Min, g.Max = math.Min(g.Min, v), math.Max(g.Max, v)
+		g.Mean += v
+	}
+	g.Mean /= float64(len(samples))
+	return g
+}
+
+// hostReport summarizes samples, nil when there are none.
+func hostReport(samples []HostSample) *HostReport {
+	if 0 == len(samples) {
+		return nil
+	}
+	rep := &HostReport{
+		Samples:         len(samples),
+		CPU:             gaugeOf(samples, func(s HostSample) float64 { return s.CPU }),
+		Memory:          gaugeOf(samples, func(s HostSample) float64 { return s.Memory }),
+		Load1:           gaugeOf(samples, func(s HostSample) float64 { return s.Load1 }),
+		ContextSwitches: gaugeOf(samples, func(s HostSample) float64 { return s.ContextSwitches }),
+		NetSent:         gaugeOf(samples, func(s HostSample) float64 { return s.NetSent }),
+		NetRecv:         gaugeOf(samples, func(s HostSample) float64 { return s.NetRecv }),
+	}
+	cores := len(samples[0].Cores)
+	for _, s := range samples {
+		cores = min(cores, len(s.Cores))
+	}
+	for i := 0; i < cores; i++ {
+		rep.Cores = append(rep.Cores, gaugeOf(samples, func(s HostSample) float64 { return s.Cores[i] }))
+	}
+	return rep
+}
+
+// hostIntervals hands every interval the last sample taken within it.
+func hostIntervals(series []Interval, samples []HostSample) {
+	for i := range series {
+		end := series[i].Offset + series[i].Duration
+		for j := range samples {
+			if samples[j].Offset > series[i].Offset && samples[j].Offset <= end {
+				series[i].Host = &samples[j]
+			}
+		}
+	}
+}
+
+func (h *HostReport) write(t *textWriter) {
+	t.printf("Host (%d samples, min/mean/max): CPU %.1f/%.1f/%.1f%%, Memory %.1f/%.1f/%.1f%%, Load %.2f/%.2f/%.2f\n",
+		h.Samples, h.CPU.Min, h.CPU.Mean, h.CPU.Max, h.Memory.Min, h.Memory.Mean, h.Memory.Max,
+		h.Load1.Min, h.Load1.Mean, h.Load1.Max)
+	t.printf("Host Context Switches/s: %.0f/%.0f/%.0f, Net Sent B/s: %.0f/%.0f/%.0f, Net Recv B/s: %.0f/%.0f/%.0f\n",
+		h.ContextSwitches.Min, h.ContextSwitches.Mean, h.ContextSwitches.Max,
+		h.NetSent.Min, h.NetSent.Mean, h.NetSent.Max, h.NetRecv.Min, h.NetRecv.Mean, h.NetRecv.Max)
+	if 0 != len(h.Cores) {
+		t.printf("Host Core Peaks:")
+		for i, core := range h.Cores {
+			t.printf(" %d:%.0f%%", i, core.Max)
+		}
+		t.printf("\n")
+	}
+}
@@ -0,0 +1,42 @@
+package kebench_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostSampling(t *testing.T) {
+	r := quietRunner(t)
+	r.Interval = 100 * time.Millisecond
+	r.HostInterval = 50 * time.Millisecond
+	rep, err := r.RunFor(context.Background(), &countUnit{sleep: time.Millisecond}, 2, 300*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	h := rep.Host
+	if nil == h || h.Samples < 4 {
+		t.Fatalf("host %+v", h)
+	}
+	if h.CPU.Min < 0 || h.CPU.Max > 100 || h.CPU.Min > h.CPU.Mean || h.CPU.Mean > h.CPU.Max {
+		t.Errorf("cpu %+v", h.CPU)
+	}
+	sampled := 0
+	for i, in := range rep.Intervals {
+		if nil == in.Host {
+			continue
+		}
+		sampled++
+		if in.Host.Offset <= in.Offset || in.Host.Offset > in.Offset+in.Duration {
+			t.Errorf("interval %d host %+v", i, in.Host)
+		}
+	}
+	if sampled < 2 {
+		t.Errorf("%d of %d intervals sampled", sampled, len(rep.Intervals))
+	}
+
+	r.HostInterval = 0
+	if rep, err = r.RunFor(context.Background(), &countUnit{}, 2, 50*time.Millisecond); nil != err || nil != rep.Host {
+		t.Errorf("sampled with HostInterval 0: %+v %v", rep.Host, err)
+	}
+}
@@ -0,0 +1,341 @@
+package kebench
+
+import (
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chart geometry of the inline SVGs, in pixels
+const (
+	chartWidth  = 760
+	chartHeight = 260
+	chartLeft   = 70
+	chartRight  = 20
+	chartTop    = 30
+	chartBottom = 40
+)
+
+var chartColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd"}
+
+// WriteHTML renders the report as a single HTML page with inline SVG
+// charts and no external assets.
+func (rep *Report) WriteHTML(w io.Writer) error {
+	t := &textWriter{w: w}
+	t.printf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Bench Report</title>\n")
+	t.printf("<style>\nbody{font-family:sans-serif;margin:2em;color:#222}\n" +
+		"table{border-collapse:collapse;margin:1em 0}\n" +
+		"td,th{border:1px solid #ccc;padding:2px 8px;text-align:right}\n" +
+		"th{background:#f4f4f4}\n.partial{color:#b00;font-weight:bold}\n" +
+		"svg{display:block;margin:1em 0}\nsvg text{font-size:11px}\n</style>\n</head>\n<body>\n")
+	t.printf("<h1>Bench Report</h1>\n")
+	if rep.Partial {
+		t.printf("<p class=\"partial\">Partial report: the bench was canceled, only completed requests are counted.</p>\n")
+	}
+	if rep.Limited {
+		t.printf("<p class=\"partial\">Stopped early: abandoned handler limit reached.</p>\n")
+	}
+	writeHTMLParams(t, rep)
+	writeHTMLSummary(t, rep)
+	if nil != rep.Trials {
+		writeHTMLTrials(t, rep.Trials)
+	}
+
+	if 0 != len(rep.Histogram) {
+		c := chart{title: "Latency histogram", bars: true, yTick: formatCount}
+		s := series{name: "requests"}
+		for i, b := range rep.Histogram {
+			s.x = append(s.x, float64(i))
+			s.y = append(s.y, float64(b.Count))
+		}
+		c.series = []series{s}
+		// label every few buckets with its upper bound
+		step := max(len(rep.Histogram)/6, 1)
+		for i := 0; i < len(rep.Histogram); i += step {
+			c.xTicks = append(c.xTicks, float64(i))
+		}
+		c.xTick = func(x float64) string {
+			return roundDuration(rep.Histogram[int(x)].Upper).String()
+		}
+		c.write(t)
+	}
+	if 0 != len(rep.Curve) {
+		// x is the number of nines, -log10(1-q)
+		c := chart{title: "Latency by percentile", yTick: formatLatency}
+		s := series{name: "latency"}
+		for _, q := range rep.Curve {
+			s.x = append(s.x, -math.Log10(1-q.Q))
+			s.y = append(s.y, float64(q.Value))
+		}
+		c.series = []series{s}
+		c.xTicks = []float64{-math.Log10(0.5)}
+		for nines := 1.0; nines <= s.x[len(s.x)-1]; nines++ {
+			c.xTicks = append(c.xTicks, nines)
+		}
+		c.xTick = func(x float64) string {
+			return strconv.FormatFloat((1-math.Pow(10, -x))*100, 'g', 6, 64) + "%"
+		}
+		c.write(t)
+	}
+	if 1 < len(rep.Intervals) {
+		tps := series{name: "TPS"}
+		errs := series{name: "error rate"}
+		quantiles := make([]series, len(rep.Latency.Percentiles))
+		for i, q := range rep.Latency.Percentiles {
+			quantiles[i].name = "P" + strconv.FormatFloat(q.Q*100, 'g', -1, 64)
+		}
+		latency := []series{{name: "max"}}
+		for _, in := range rep.Intervals {
+			x := (in.Offset + in.Duration).Seconds()
+			tps.x, tps.y = append(tps.x, x), append(tps.y, in.TPS)
+			errs.x, errs.y = append(errs.x, x), append(errs.y, in.ErrorRate*100)
+			if 0 == in.Requests {
+				continue
+			}
+			latency[0].x = append(latency[0].x, x)
+			latency[0].y = append(latency[0].y, float64(in.Latency.Max))
+			for i := range quantiles {
+				quantiles[i].x = append(quantiles[i].x, x)
+				quantiles[i].y = append(quantiles[i].y, float64(in.Latency.Percentiles[i].Value))
+			}
+		}
+		// only the upper percentiles, the rest crowd the chart
+		if n := len(quantiles); n > 3 {
+			quantiles = quantiles[n-3:]
+		}
+		latency = append(quantiles, latency...)
+		seconds := func(x float64) string { return strconv.FormatFloat(x, 'g', 4, 64) + "s" }
+		chart{title: "TPS over time", series: []series{tps}, xTick: seconds, yTick: formatCount}.write(t)
+		chart{title: "Error rate over time (%)", series: []series{errs}, xTick: seconds, yTick: formatCount}.write(t)
+		chart{title: "Latency over time", series: latency, xTick: seconds, yTick: formatLatency}.write(t)
+	}
+	t.printf("</body>\n</html>\n")
+	return t.err
+}
+
+func writeHTMLParams(t *textWriter, rep *Report) {
+	p := rep.Params
+	t.printf("<h2>Parameters</h2>\n<table>\n")
+	if !p.Start.IsZero() {
+		t.printf("<tr><th>Start</th><td>%s</td></tr>\n", html.EscapeString(p.Start.Format(time.RFC3339)))
+	}
+	if 0 != p.Total {
+		t.printf("<tr><th>Total</th><td>%d</td></tr>\n", p.Total)
+	}
+	t.printf("<tr><th>Timeout</th><td>%v</td></tr>\n", p.Timeout)
+	if 0 != p.Interval {
+		t.printf("<tr><th>Interval</th><td>%v</td></tr>\n", p.Interval)
+	}
+	t.printf("<tr><th>Percentiles</th><td>%v</td></tr>\n", p.Method)
+	t.printf("<tr><th>Wall Time</th><td>%v</td></tr>\n", rep.Wall)
+	t.printf("</table>\n")
+	if 0 == len(p.Stages) {
+		return
+	}
+	t.printf("<table>\n<tr><th>Stage</th><th>Duration</th><th>Concurrency</th><th>Rate</th><th>Arrival</th><th>Ramp</th></tr>\n")
+	for i, s := range p.Stages {
+		t.printf("<tr><td>%d</td><td>%v</td><td>%d</td>", i+1, s.Duration, s.Concurrency)
+		if s.Rate > 0 {
+			t.printf("<td>%.2f</td><td>%v</td>", s.Rate, p.Arrival)
+		} else {
+			t.printf("<td>closed loop</td><td></td>")
+		}
+		t.printf("<td>%t</td></tr>\n", s.Ramp)
+	}
+	t.printf("</table>\n")
+}
+
+func writeHTMLSummary(t *textWriter, rep *Report) {
+	t.printf("<h2>Summary</h2>\n<table>\n")
+	t.printf("<tr><th>Requests</th><td>%d</td></tr>\n", rep.Requests)
+	t.printf("<tr><th>Errors</th><td>%d (%.2f%%)</td></tr>\n", rep.Errors, rep.ErrorRate*100)
+	if 0 != rep.Rate {
+		t.printf("<tr><th>Offered Rate</th><td>%.2f</td></tr>\n", rep.Rate)
+	}
+	t.printf("<tr><th>TPS</th><td>%.2f</td></tr>\n", rep.TPS)
+	t.printf("<tr><th>Min</th><td>%v</td></tr>\n<tr><th>Mean</th><td>%v</td></tr>\n", rep.Latency.Min, rep.Latency.Mean)
+	t.printf("<tr><th>Max</th><td>%v</td></tr>\n<tr><th>StdDev</th><td>%v</td></tr>\n", rep.Latency.Max, rep.Latency.StdDev)
+	for _, q := range rep.Latency.Percentiles {
+		t.printf("<tr><th>P%g</th><td>%v</td></tr>\n", q.Q*100, q.Value)
+	}
+	t.printf("</table>\n")
+	if 0 != len(rep.ErrorTypes) {
+		t.printf("<table>\n<tr><th>Error</th><th>Count</th></tr>\n")
+		for _, name := range sortedKeys(rep.ErrorTypes) {
+			t.printf("<tr><td>%s</td><td>%d</td></tr>\n", html.EscapeString(name), rep.ErrorTypes[name])
+		}
+		t.printf("</table>\n")
+	}
+	if 0 != len(rep.Operations) {
+		writeHTMLSummaryHeader(t, "Operation", rep.Latency.Percentiles)
+		for _, op := range rep.Operations {
+			writeHTMLSummaryRow(t, op.Name, op.Summary)
+		}
+		t.printf("</table>\n")
+	}
+	if 0 != len(rep.Stages) {
+		writeHTMLSummaryHeader(t, "Stage", rep.Latency.Percentiles)
+		for i, stage := range rep.Stages {
+			writeHTMLSummaryRow(t, strconv.Itoa(i+1), stage.Summary)
+		}
+		t.printf("</table>\n")
+	}
+}
+
+func writeHTMLTrials(t *textWriter, s *TrialSummary) {
+	t.printf("<h2>Trials</h2>\n<table>\n<tr><th>Trial</th><th>Wall</th><th>Requests</th><th>Errors</th><th>TPS</th>")
+	for _, e := range s.Percentiles {
+		t.printf("<th>P%g</th>", e.Q*100)
+	}
+	t.printf("<th>Outlier</th></tr>\n")
+	for i, run := range s.Runs {
+		t.printf("<tr><td>%d</td><td>%v</td><td>%d</td><td>%d</td><td>%.2f</td>", i+1, run.Wall, run.Requests, run.Errors, run.TPS)
+		for _, q := range run.Latency {
+			t.printf("<td>%v</td>", q.Value)
+		}
+		t.printf("<td>%s</td></tr>\n", html.EscapeString(strings.Join(run.Outlier, " ")))
+	}
+	t.printf("<tr><th>%g%% CI</th><td></td><td></td><td></td><td>%.2f ± %.2f</td>", s.Confidence*100, s.TPS.Mean, s.TPS.Margin)
+	for _, e := range s.Percentiles {
+		t.printf("<td>%v ± %v</td>", time.Duration(e.Mean), time.Duration(e.Margin))
+	}
+	t.printf("<td></td></tr>\n</table>\n")
+}
+
+func writeHTMLSummaryHeader(t *textWriter, name string, percentiles []Quantile) {
+	t.printf("<table>\n<tr><th>%s</th><th>Requests</th><th>Errors</th><th>TPS</th><th>Mean</th>", name)
+	for _, q := range percentiles {
+		t.printf("<th>P%g</th>", q.Q*100)
+	}
+	t.printf("</tr>\n")
+}
+
+func writeHTMLSummaryRow(t *textWriter, name string, s Summary) {
+	t.printf("<tr><td>%s</td><td>%d</td><td>%d</td><td>%.2f</td><td>%v</td>",
+		html.EscapeString(name), s.Requests, s.Errors, s.TPS, s.Latency.Mean)
+	for _, q := range s.Latency.Percentiles {
+		t.printf("<td>%v</td>", q.Value)
+	}
+	t.printf("</tr>\n")
+}
+
+type series struct {
+	name string
+	x, y []float64
+}
+
+// chart is a line chart, or a bar chart of a single series, drawn on a
+// y axis starting at zero. xTicks defaults to five evenly spaced ticks.
+type chart struct {
+	title  string
+	series []series
+	bars   bool
+	xTicks []float64
+	xTick  func(float64) string
+	yTick  func(float64) string
+}
+
+func (c chart) write(t *textWriter) {
+	xmin, xmax, ymax := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range c.series {
+		for i := range s.x {
+			xmin, xmax = math.Min(xmin, s.x[i]), math.Max(xmax, s.x[i])
+			ymax = math.Max(ymax, s.y[i])
+		}
+	}
+	if math.IsInf(xmin, 0) {
+		return
+	}
+	if c.bars {
+		// every bar gets a slot of its own
+		xmax++
+	}
+	if xmax <= xmin {
+		xmax = xmin + 1
+	}
+	if ymax <= 0 {
+		ymax = 1
+	}
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	px := func(x float64) float64 { return chartLeft + (x-xmin)/(xmax-xmin)*plotW }
+	py := func(y float64) float64 { return chartTop + plotH - y/ymax*plotH }
+
+	t.printf("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	t.printf("<text x=\"%d\" y=\"18\" font-weight=\"bold\">%s</text>\n", chartLeft, html.EscapeString(c.title))
+	// y grid
+	for i := 0; i <= 4; i++ {
+		y := ymax * float64(i) / 4
+		t.printf("<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#e4e4e4\"/>\n",
+			chartLeft, py(y), chartWidth-chartRight, py(y))
+		t.printf("<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n",
+			chartLeft-6, py(y)+4, html.EscapeString(c.yTick(y)))
+	}
+	// x ticks
+	ticks := c.xTicks
+	if nil == ticks {
+		for i := 0; i <= 4; i++ {
+			ticks = append(ticks, xmin+(xmax-xmin)*float64(i)/4)
+		}
+	}
+	for _, x := range ticks {
+		cx := px(x)
+		if c.bars {
+			cx = px(x + 0.5)
+		}
+		t.printf("<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%d\" stroke=\"#888\"/>\n",
+			cx, chartHeight-chartBottom, cx, chartHeight-chartBottom+4)
+		t.printf("<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%s</text>\n",
+			cx, chartHeight-chartBottom+16, html.EscapeString(c.xTick(x)))
+	}
+	t.printf("<rect x=\"%d\" y=\"%d\" width=\"%.0f\" height=\"%.0f\" fill=\"none\" stroke=\"#888\"/>\n",
+		chartLeft, chartTop, plotW, plotH)
+
+	for i, s := range c.series {
+		color := chartColors[i%len(chartColors)]
+		if c.bars {
+			width := plotW / (xmax - xmin)
+			for j := range s.x {
+				t.printf("<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\"><title>%s</title></rect>\n",
+					px(s.x[j])+0.5, py(s.y[j]), math.Max(width-1, 0.5), py(0)-py(s.y[j]), color,
+					html.EscapeString(c.xTick(s.x[j])+": "+c.yTick(s.y[j])))
+			}
+			continue
+		}
+		t.printf("<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"1.5\" points=\"", color)
+		for j := range s.x {
+			t.printf("%.1f,%.1f ", px(s.x[j]), py(s.y[j]))
+		}
+		t.printf("\"/>\n")
+		if 1 < len(c.series) {
+			t.printf("<text x=\"%d\" y=\"18\" fill=\"%s\" text-anchor=\"end\">%s</text>\n",
+				chartWidth-chartRight-90*(len(c.series)-1-i), color, html.EscapeString(s.name))
+		}
+	}
+	t.printf("</svg>\n")
+}
+
+func formatCount(v float64) string {
+	if v >= 100 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 3, 64)
+}
+
+func formatLatency(v float64) string {
+	return roundDuration(time.Duration(v)).String()
+}
+
+// roundDuration keeps three significant digits of d.
+func roundDuration(d time.Duration) time.Duration {
+	for unit := time.Duration(1); unit < time.Hour; unit *= 10 {
+		if d < unit*1000 {
+			return d.Round(unit)
+		}
+	}
+	return d
+}
@@ -0,0 +1,111 @@
+package kebench
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxAbandoned is the abandoned handler limit of a new Runner.
+const DefaultMaxAbandoned = 1024
+
+// LeakStats counts handlers still running after their request timed out.
+// Abandoned is how many requests gave up on their handler, Late how many
+// of those handlers returned afterwards, InFlight how many are running at
+// the end of the bench and Peak the most that were running at once.
+// Handlers cut off by cancelling the bench were not abandoned by a timeout
+// and are not counted.
+// InFlight and Peak include handlers left over from the warm-up or an
+// earlier trial, Late only counts those of the bench itself, so it never
+// exceeds Abandoned.
+type LeakStats struct {
+	Abandoned int64
+	Late      int64
+	InFlight  int64
+	Peak      int64
+}
+
+// leaks is the live counterpart of LeakStats. Handlers of an earlier phase
+// may still be running, so it outlives a single benching call.
+type leaks struct {
+	inflight  int64
+	peak      int64
+	abandoned int64
+	late      int64
+	// phase counts the resets, handlers of an earlier phase landing late
+	// are not counted against the current one
+	phase int64
+	// limited is raised when the limit ended the current phase
+	limited int32
+}
+
+// abandon records a handler left running by a timed out request.
+func (l *leaks) abandon() {
+	n := atomic.AddInt64(&l.inflight, 1)
+	atomic.AddInt64(&l.abandoned, 1)
+	for {
+		peak := atomic.LoadInt64(&l.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&l.peak, peak, n) {
+			return
+		}
+	}
+}
+
+// current is the phase a request started now belongs to.
+func (l *leaks) current() int64 {
+	return atomic.LoadInt64(&l.phase)
+}
+
+// land records an abandoned handler of phase that finally returned.
+func (l *leaks) land(phase int64) {
+	atomic.AddInt64(&l.inflight, -1)
+	if phase == atomic.LoadInt64(&l.phase) {
+		atomic.AddInt64(&l.late, 1)
+	}
+}
+
+// reset starts counting afresh, keeping track of handlers still running.
+func (l *leaks) reset() {
+	atomic.AddInt64(&l.phase, 1)
+	atomic.StoreInt64(&l.abandoned, 0)
+	atomic.StoreInt64(&l.late, 0)
+	atomic.StoreInt64(&l.peak, atomic.LoadInt64(&l.inflight))
+}
+
+func (l *leaks) stats() LeakStats {
+	return LeakStats{
+		Abandoned: atomic.LoadInt64(&l.abandoned),
+		Late:      atomic.LoadInt64(&l.late),
+		InFlight:  atomic.LoadInt64(&l.inflight),
+		Peak:      atomic.LoadInt64(&l.peak),
+	}
+}
+
+// throttle holds a worker back while too many abandoned handlers are
+// running. It returns false when the worker should not go on, either
+// because StopOnAbandoned is set or because the phase stopped meanwhile.
+func (r *Runner) throttle(stop *halt) bool {
+	if r.MaxAbandoned <= 0 || atomic.LoadInt64(&r.leaks.inflight) < int64(r.MaxAbandoned) {
+		return true
+	}
+	if r.StopOnAbandoned {
+		atomic.StoreInt32(&r.leaks.limited, 1)
+		stop.stop()
+		return false
+	}
+	for atomic.LoadInt64(&r.leaks.inflight) >= int64(r.MaxAbandoned) {
+		select {
+		case <-stop.done:
+			return false
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return true
+}
+
+func (s LeakStats) write(t *textWriter) {
+	if 0 == s.Abandoned && 0 == s.InFlight {
+		return
+	}
+	t.printf("Abandoned Handlers: %d, Late: %d, Still Running: %d, Peak: %d\n",
+		s.Abandoned, s.Late, s.InFlight, s.Peak)
+}
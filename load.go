@@ -0,0 +1,209 @@
+package kebench
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Arrival selects how an open-loop load spaces its requests.
+type Arrival int
+
+const (
+	// ArrivalFixed issues requests at a constant interval of 1/Rate.
+	ArrivalFixed Arrival = iota
+	// ArrivalPoisson draws exponential inter-arrival times with mean 1/Rate.
+	ArrivalPoisson
+)
+
+func (a Arrival) String() string {
+	switch a {
+	case ArrivalFixed:
+		return "fixed"
+	case ArrivalPoisson:
+		return "poisson"
+	}
+	return "arrival(" + strconv.Itoa(int(a)) + ")"
+}
+
+// Load describes how much work one bench phase issues.
+// The phase stops after Total requests or once Duration has elapsed,
+// whichever comes first. A zero value disables that limit, but at least
+// one of them must be set.
+//
+// A zero Rate runs closed-loop: each of the Concurrency workers issues its
+// next request as soon as the previous one returns. A positive Rate runs
+// open-loop: requests are scheduled Rate times per second regardless of
+// how many are in flight, Concurrency caps the requests served at once and
+// anything beyond that queues. Latency is then measured from the scheduled
+// start, so a slow server shows up as queueing delay instead of a lower
+// offered load.
+type Load struct {
+	Concurrency int
+	Total       int64
+	Duration    time.Duration
+	Rate        float64
+	Arrival     Arrival
+}
+
+func (l Load) validate() error {
+	if l.Concurrency < 1 {
+		return ErrConcurrency
+	}
+	if 0 == l.Total && 0 == l.Duration {
+		return ErrUnbounded
+	}
+	if l.Rate < 0 {
+		return ErrRate
+	}
+	return nil
+}
+
+func (l Load) plan() plan {
+	return plan{
+		stages: []Stage{{
+			Duration:    l.Duration,
+			Concurrency: l.Concurrency,
+			Rate:        l.Rate,
+		}},
+		total:   l.Total,
+		arrival: l.Arrival,
+	}
+}
+
+// plan is the schedule a bench phase follows. A Load resolves to a single
+// stage, a Profile keeps its stages. A stage without a duration lasts
+// until total requests were issued.
+type plan struct {
+	stages  []Stage
+	total   int64
+	arrival Arrival
+}
+
+// open reports whether requests are paced by rate rather than by workers.
+func (p plan) open() bool {
+	for _, s := range p.stages {
+		if s.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// duration is the scheduled length of the plan, 0 when it is unlimited.
+func (p plan) duration() time.Duration {
+	var d time.Duration
+	for _, s := range p.stages {
+		if 0 == s.Duration {
+			return 0
+		}
+		d += s.Duration
+	}
+	return d
+}
+
+func (p plan) maxConcurrency() int {
+	n := 1
+	for _, s := range p.stages {
+		n = max(n, s.Concurrency)
+	}
+	return n
+}
+
+// level returns the stage, worker count and rate scheduled elapsed into
+// the plan. A ramping stage interpolates from the level of the stage
+// before it, the first stage ramps up from a single worker and no load.
+func (p plan) level(elapsed time.Duration) (stage int, concurrency int, rate float64) {
+	from := Stage{Concurrency: 1}
+	for i, s := range p.stages {
+		if 0 != s.Duration && elapsed >= s.Duration && i != len(p.stages)-1 {
+			elapsed -= s.Duration
+			from = s
+			continue
+		}
+		if !s.Ramp || 0 == s.Duration || elapsed >= s.Duration {
+			return i, s.Concurrency, s.Rate
+		}
+		f := float64(elapsed) / float64(s.Duration)
+		concurrency = from.Concurrency + int(math.Round(f*float64(s.Concurrency-from.Concurrency)))
+		rate = from.Rate + f*(s.Rate-from.Rate)
+		return i, max(concurrency, 1), rate
+	}
+	return 0, 0, 0
+}
+
+// tick is the intended start of an open-loop request.
+type tick struct {
+	at    time.Time
+	stage int
+}
+
+// dispatch sends the intended start of every open-loop request to ticks.
+// The schedule is fixed from start, so a send that blocks because all
+// workers are busy does not push later requests back.
+func (r *Runner) dispatch(p plan, start time.Time, stop *halt, ticks chan<- tick) {
+	defer close(ticks)
+	var (
+		rng    = rand.New(rand.NewSource(time.Now().UnixNano()))
+		offset float64
+		timer  = time.NewTimer(0)
+	)
+	defer timer.Stop()
+	<-timer.C
+	for i := int64(0); 0 == p.total || i < p.total; {
+		intended := start.Add(time.Duration(offset))
+		if d := intended.Sub(r.Clock.Now()); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-timer.C:
+			case <-stop.done:
+				return
+			}
+		}
+		stage, _, rate := p.level(time.Duration(offset))
+		if rate <= 0 {
+			// nothing scheduled right now, look again shortly
+			offset += float64(steerInterval)
+			continue
+		}
+		select {
+		case ticks <- tick{at: intended, stage: stage}:
+		case <-stop.done:
+			return
+		}
+		i++
+		interval := float64(time.Second) / rate
+		switch p.arrival {
+		case ArrivalPoisson:
+			offset += rng.ExpFloat64() * interval
+		default:
+			offset += interval
+		}
+	}
+}
+
+// halt is a one-shot stop signal, cheap to poll from hot loops and
+// selectable from blocking ones.
+type halt struct {
+	flag int32
+	once sync.Once
+	done chan struct{}
+}
+
+func newHalt() *halt {
+	return &halt{done: make(chan struct{})}
+}
+
+func (h *halt) stop() {
+	h.once.Do(func() {
+		atomic.StoreInt32(&h.flag, 1)
+		close(h.done)
+	})
+}
+
+func (h *halt) stopped() bool {
+	return 0 != atomic.LoadInt32(&h.flag)
+}
@@ -0,0 +1,159 @@
+package kebench
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricBuckets are the upper bounds in seconds of the latency histogram
+// served as metrics.
+var metricBuckets = [...]float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// phases a Runner goes through, as the metrics name them
+const (
+	phaseIdle int32 = iota
+	phaseWarmUp
+	phaseBench
+	phaseCooldown
+	phaseSearch
+)
+
+var phaseNames = [...]string{"idle", "warmup", "bench", "cooldown", "search"}
+
+// request outcomes, as the metrics name them
+const (
+	resultOK = iota
+	resultError
+	resultTimeout
+)
+
+var resultNames = [...]string{"ok", "error", "timeout"}
+
+// metrics is the live state of a Runner served in the Prometheus text
+// format. Counters are kept over every bench of the Runner, requests of
+// the warm-up included.
+type metrics struct {
+	results  [len(resultNames)]int64
+	inflight int64
+	workers  int64
+	phase    int32
+	buckets  [len(metricBuckets) + 1]int64
+	sum      int64
+}
+
+// MetricsHandler serves the live metrics of the Runner in the Prometheus
+// text format. It must be called before a bench for that bench to count.
+func (r *Runner) MetricsHandler() http.Handler {
+	if nil == r.metrics {
+		r.metrics = &metrics{}
+	}
+	return r.metrics
+}
+
+// serveMetrics listens on Metrics, when set, until the returned stop is
+// called.
+func (r *Runner) serveMetrics() (stop func(), err error) {
+	if "" == r.Metrics {
+		return func() { r.metrics.enter(phaseIdle) }, nil
+	}
+	ln, err := net.Listen("tcp", r.Metrics)
+	if nil != err {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.MetricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	var done sync.WaitGroup
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		srv.Serve(ln)
+	}()
+	return func() {
+		r.metrics.enter(phaseIdle)
+		srv.Close()
+		done.Wait()
+	}, nil
+}
+
+func (m *metrics) enter(phase int32) {
+	if nil != m {
+		atomic.StoreInt32(&m.phase, phase)
+	}
+}
+
+func (m *metrics) working(delta int64) {
+	if nil != m {
+		atomic.AddInt64(&m.workers, delta)
+	}
+}
+
+func (m *metrics) issuing(delta int64) {
+	if nil != m {
+		atomic.AddInt64(&m.inflight, delta)
+	}
+}
+
+func (m *metrics) observe(entry RecordEntry) {
+	if nil == m {
+		return
+	}
+	result := resultOK
+	switch {
+	case ErrTimeout == entry.Err:
+		result = resultTimeout
+	case nil != entry.Err:
+		result = resultError
+	}
+	atomic.AddInt64(&m.results[result], 1)
+	latency := entry.Latency()
+	i := 0
+	for i < len(metricBuckets) && float64(latency) > metricBuckets[i]*1e9 {
+		i++
+	}
+	atomic.AddInt64(&m.buckets[i], 1)
+	atomic.AddInt64(&m.sum, latency)
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	t := &textWriter{w: w}
+	t.printf("# HELP kebench_requests_total Requests completed, by result.\n")
+	t.printf("# TYPE kebench_requests_total counter\n")
+	for i, name := range resultNames {
+		t.printf("kebench_requests_total{result=%q} %d\n", name, atomic.LoadInt64(&m.results[i]))
+	}
+	t.printf("# HELP kebench_in_flight_requests Requests being handled.\n")
+	t.printf("# TYPE kebench_in_flight_requests gauge\n")
+	t.printf("kebench_in_flight_requests %d\n", atomic.LoadInt64(&m.inflight))
+	t.printf("# HELP kebench_request_duration_seconds Latency of completed requests from their intended start.\n")
+	t.printf("# TYPE kebench_request_duration_seconds histogram\n")
+	var count int64
+	for i, upper := range metricBuckets {
+		count += atomic.LoadInt64(&m.buckets[i])
+		t.printf("kebench_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(upper, 'g', -1, 64), count)
+	}
+	count += atomic.LoadInt64(&m.buckets[len(metricBuckets)])
+	t.printf("kebench_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	t.printf("kebench_request_duration_seconds_sum %g\n", time.Duration(atomic.LoadInt64(&m.sum)).Seconds())
+	t.printf("kebench_request_duration_seconds_count %d\n", count)
+	t.printf("# HELP kebench_active_workers Workers issuing requests.\n")
+	t.printf("# TYPE kebench_active_workers gauge\n")
+	t.printf("kebench_active_workers %d\n", atomic.LoadInt64(&m.workers))
+	t.printf("# HELP kebench_phase The phase the runner is in.\n")
+	t.printf("# TYPE kebench_phase gauge\n")
+	phase := atomic.LoadInt32(&m.phase)
+	for i, name := range phaseNames {
+		v := 0
+		if int32(i) == phase {
+			v = 1
+		}
+		t.printf("kebench_phase{phase=%q} %d\n", name, v)
+	}
+}
@@ -0,0 +1,54 @@
+package kebench_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, h http.Handler) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Result().Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	r := quietRunner(t)
+	h := r.MetricsHandler()
+	u := &countUnit{sleep: 2 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.RunFor(context.Background(), u, 4, 300*time.Millisecond); nil != err {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	live := scrape(t, h)
+	for _, line := range []string{`kebench_phase{phase="bench"} 1`, "kebench_active_workers 4\n"} {
+		if !strings.Contains(live, line) {
+			t.Errorf("live metrics miss %q:\n%s", line, live)
+		}
+	}
+	<-done
+	end := scrape(t, h)
+	for _, line := range []string{
+		`kebench_requests_total{result="ok"} `,
+		`kebench_request_duration_seconds_bucket{le="+Inf"} `,
+		`kebench_phase{phase="idle"} 1`,
+		"kebench_active_workers 0\n",
+		"kebench_in_flight_requests 0\n",
+	} {
+		if !strings.Contains(end, line) {
+			t.Errorf("metrics miss %q:\n%s", line, end)
+		}
+	}
+	if strings.Contains(end, `kebench_requests_total{result="ok"} 0`) {
+		t.Errorf("no requests counted:\n%s", end)
+	}
+}
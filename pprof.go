@@ -0,0 +1,347 @@
+package kebench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// PprofKinds are the pprof profiles a Runner can capture.
+var PprofKinds = []string{"cpu", "heap", "mutex", "block", "goroutine"}
+
+// Pprof configures the pprof profiles captured between the Begin and End
+// of the units. CPU profiles cover exactly that span. Heap, mutex and
+// block profiles count over the whole process, they are written as the
+// difference between their state at the start and at the end, like those
+// net/http/pprof serves for a seconds parameter: the in-use heap is its
+// growth over the bench, the rest only what the bench allocated or waited
+// for, earlier trials and warm-ups left out. Goroutine profiles are taken
+// at the end.
+//
+// Mutex and block profiling is switched on for the span. The mutex profile
+// fraction is restored after it, the block profile rate, which the runtime
+// does not tell, is set back to BlockRate.
+//
+// Target is the pprof URL of the system under test, e.g.
+// http://localhost:6060/debug/pprof, to pull the same profiles from. Its
+// cpu, heap, mutex and block profiles are asked for the scheduled length
+// of the bench, which a bench bounded by a total alone does not have: its
+// cpu profile is skipped and the rest taken at the end. A bench that stops
+// before its scheduled length, e.g. once its total is reached, drops them
+// rather than waiting for the target.
+//
+// Files are named Path.kind.pprof and Path.target.kind.pprof, Path
+// defaulting to the first report output written to a file without its
+// extension. Trials number the path.
+type Pprof struct {
+	Kinds     []string
+	Target    string
+	Path      string
+	BlockRate int
+}
+
+func (p Pprof) validate() error {
+	for _, kind := range p.Kinds {
+		if !slices.Contains(PprofKinds, kind) {
+			return fmt.Errorf("%w: %q", ErrPprof, kind)
+		}
+	}
+	return nil
+}
+
+// profilePrefix is the path the profiles of trial i are named after.
+func (r *Runner) profilePrefix(i int) string {
+	prefix := r.Pprof.Path
+	for _, o := range r.Outputs {
+		if "" == prefix && "" != o.Path && "-" != o.Path {
+			prefix = strings.TrimSuffix(o.Path, filepath.Ext(o.Path))
+		}
+	}
+	if "" == prefix {
+		prefix = "kebench"
+	}
+	return samplePath(prefix, i, r.Trials)
+}
+
+// profiler captures the profiles of one trial.
+type profiler struct {
+	prefix string
+	kinds  []string
+	cpu    *os.File
+	mutex  int
+	block  int
+	// bases are the cumulative profiles as the bench started
+	bases  map[string]*profile.Profile
+	remote sync.WaitGroup
+	// end is closed once the bench ends
+	end chan struct{}
+	// cut cancels the profiles the target takes over the bench, which
+	// stop does when the bench ends before due
+	cut   context.CancelFunc
+	due   time.Time
+	mtx   sync.Mutex
+	files []string
+	errs  []error
+}
+
+// startProfiles starts capturing the profiles of trial i, nil when there
+// are none to capture.
+func (r *Runner) startProfiles(ctx context.Context, p plan, i int) *profiler {
+	if 0 == len(r.Pprof.Kinds) {
+		return nil
+	}
+	pr := &profiler{
+		prefix: r.profilePrefix(i),
+		kinds:  r.Pprof.Kinds,
+		block:  r.Pprof.BlockRate,
+		bases:  map[string]*profile.Profile{},
+		end:    make(chan struct{}),
+	}
+	for _, kind := range pr.kinds {
+		switch kind {
+		case "cpu":
+			path := pr.prefix + ".cpu.pprof"
+			f, err := os.Create(path)
+			if nil == err {
+				if err = pprof.StartCPUProfile(f); nil != err {
+					f.Close()
+					os.Remove(path)
+				}
+			}
+			if nil != err {
+				pr.fail(kind, err)
+				continue
+			}
+			pr.cpu = f
+			pr.done(path)
+		case "mutex":
+			pr.mutex = runtime.SetMutexProfileFraction(1)
+		case "block":
+			runtime.SetBlockProfileRate(1)
+		case "heap":
+			// the heap profile is as of the last gc
+			runtime.GC()
+		}
+		if "cpu" == kind || "goroutine" == kind {
+			continue
+		}
+		base, err := snapshot(kind)
+		if nil != err {
+			pr.fail(kind, err)
+			continue
+		}
+		pr.bases[kind] = base
+	}
+	if "" != r.Pprof.Target {
+		pr.pull(ctx, r.Pprof.Target, p.duration())
+	}
+	return pr
+}
+
+// pull asks the target for its profiles over d, the delta ones starting
+// now and the rest once the bench ends.
+func (pr *profiler) pull(ctx context.Context, target string, d time.Duration) {
+	base := strings.TrimSuffix(target, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	seconds := int64((d + time.Second - 1) / time.Second)
+	over, cut := context.WithCancel(ctx)
+	pr.cut, pr.due = cut, time.Now().Add(d)
+	for _, kind := range pr.kinds {
+		name := kind
+		if "cpu" == kind {
+			name = "profile"
+		}
+		url := base + "/" + name
+		switch {
+		case "goroutine" == kind:
+		case 0 != seconds:
+			url += "?seconds=" + strconv.FormatInt(seconds, 10)
+		case "cpu" == kind:
+			pr.fail("target cpu", fmt.Errorf("skipped, the bench has no scheduled length"))
+			continue
+		}
+		path := pr.prefix + ".target." + kind + ".pprof"
+		if strings.Contains(url, "?") {
+			pr.remote.Add(1)
+			go func() {
+				defer pr.remote.Done()
+				pr.fetch(over, url, path, seconds)
+			}()
+			continue
+		}
+		// taken at the end
+		pr.remote.Add(1)
+		go func() {
+			defer pr.remote.Done()
+			select {
+			case <-ctx.Done():
+			case <-pr.end:
+			}
+			pr.fetch(context.WithoutCancel(ctx), url, path, 0)
+		}()
+	}
+}
+
+func (pr *profiler) fetch(ctx context.Context, url, path string, seconds int64) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(seconds)*time.Second+30*time.Second)
+	defer cancel()
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if nil != err {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			return err
+		}
+		defer resp.Body.Close()
+		if http.StatusOK != resp.StatusCode {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		f, err := os.Create(path)
+		if nil != err {
+			return err
+		}
+		if _, err = io.Copy(f, resp.Body); nil != err {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}()
+	if nil != err {
+		if errors.Is(err, context.Canceled) {
+			err = fmt.Errorf("dropped, the bench ended first: %w", err)
+		}
+		pr.fail(url, err)
+		return
+	}
+	pr.done(path)
+}
+
+// stop ends the capture and writes the profiles taken at the end,
+// returning the files written once the target has answered too.
+func (pr *profiler) stop() []string {
+	if nil == pr {
+		return nil
+	}
+	if nil != pr.cpu {
+		pprof.StopCPUProfile()
+		if err := pr.cpu.Close(); nil != err {
+			pr.fail("cpu", err)
+		}
+	}
+	for _, kind := range pr.kinds {
+		switch kind {
+		case "cpu":
+			continue
+		case "heap":
+			runtime.GC()
+		}
+		path := pr.prefix + "." + kind + ".pprof"
+		err := writeProfile(kind, path, pr.bases[kind])
+		switch kind {
+		case "mutex":
+			runtime.SetMutexProfileFraction(pr.mutex)
+		case "block":
+			runtime.SetBlockProfileRate(pr.block)
+		}
+		if nil != err {
+			pr.fail(kind, err)
+			continue
+		}
+		pr.done(path)
+	}
+	close(pr.end)
+	if nil != pr.cut {
+		if time.Now().Before(pr.due) {
+			pr.cut()
+		}
+		defer pr.cut()
+	}
+	pr.remote.Wait()
+	for _, err := range pr.errs {
+		fmt.Println("profile", err)
+	}
+	slices.Sort(pr.files)
+	return pr.files
+}
+
+// snapshot is the profile of kind as it stands.
+func snapshot(kind string) (*profile.Profile, error) {
+	p := pprof.Lookup(kind)
+	if nil == p {
+		return nil, fmt.Errorf("no %s profile", kind)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, 0); nil != err {
+		return nil, err
+	}
+	return profile.Parse(&buf)
+}
+
+// writeProfile writes the profile of kind to path, less base when that is
+// not nil.
+func writeProfile(kind, path string, base *profile.Profile) error {
+	p := pprof.Lookup(kind)
+	if nil == p {
+		return fmt.Errorf("no %s profile", kind)
+	}
+	f, err := os.Create(path)
+	if nil != err {
+		return err
+	}
+	if nil == base {
+		err = p.WriteTo(f, 0)
+	} else {
+		err = writeProfileDelta(f, kind, base)
+	}
+	if nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeProfileDelta(w io.Writer, kind string, base *profile.Profile) error {
+	end, err := snapshot(kind)
+	if nil != err {
+		return err
+	}
+	base.Scale(-1)
+	delta, err := profile.Merge([]*profile.Profile{base, end})
+	if nil != err {
+		return err
+	}
+	delta.TimeNanos = base.TimeNanos
+	delta.DurationNanos = end.TimeNanos - base.TimeNanos
+	return delta.Write(w)
+}
+
+func (pr *profiler) done(path string) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	pr.files = append(pr.files, path)
+}
+
+func (pr *profiler) fail(what string, err error) {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	pr.errs = append(pr.errs, fmt.Errorf("%s: %w", what, err))
+}
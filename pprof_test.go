@@ -0,0 +1,166 @@
+package kebench_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func TestPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	target := httptest.NewServer(mux)
+	defer target.Close()
+
+	prefix := filepath.Join(t.TempDir(), "bench")
+	r := quietRunner(t)
+	r.Pprof = kebench.Pprof{Kinds: kebench.PprofKinds, Target: target.URL + "/debug/pprof", Path: prefix}
+	rep, err := r.Run(context.Background(), &countUnit{sleep: time.Millisecond}, 2, 100)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the target cpu profile needs a bench of a scheduled length
+	want := []string{"block", "cpu", "goroutine", "heap", "mutex", "target.block", "target.goroutine", "target.heap", "target.mutex"}
+	if len(want) != len(rep.Profiles) {
+		t.Fatalf("profiles %v", rep.Profiles)
+	}
+	for i, name := range want {
+		path := prefix + "." + name + ".pprof"
+		if path != rep.Profiles[i] {
+			t.Errorf("profile %d %s, want %s", i, rep.Profiles[i], path)
+		}
+		if info, err := os.Stat(path); nil != err || 0 == info.Size() {
+			t.Errorf("profile %s: %v", path, err)
+		}
+	}
+
+	r.Pprof = kebench.Pprof{Kinds: []string{"trace"}}
+	if _, err := r.Run(context.Background(), &countUnit{}, 1, 1); !errors.Is(err, kebench.ErrPprof) {
+		t.Errorf("err %v, want ErrPprof", err)
+	}
+}
+
+// profiledUnit blocks and allocates in a function named after its trial.
+type profiledUnit struct {
+	trial int32
+}
+
+func (u *profiledUnit) Begin() error {
+	atomic.AddInt32(&u.trial, 1)
+	return nil
+}
+
+func (u *profiledUnit) End() error    { return nil }
+func (u *profiledUnit) WarmUp() error { return nil }
+
+func (u *profiledUnit) Run() error {
+	if 1 == atomic.LoadInt32(&u.trial) {
+		blockFirstTrial()
+	} else {
+		blockSecondTrial()
+	}
+	return nil
+}
+
+//go:noinline
+func blockFirstTrial() {
+	ch := make(chan []byte)
+	go func() { ch <- make([]byte, 1<<20) }()
+	<-ch
+}
+
+//go:noinline
+func blockSecondTrial() {
+	ch := make(chan []byte)
+	go func() { ch <- make([]byte, 1<<20) }()
+	<-ch
+}
+
+// functions are the names of the functions the profile at path saw
+// anything in, a line each.
+func functions(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var names strings.Builder
+	for _, s := range p.Sample {
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				names.WriteString(line.Function.Name + "\n")
+			}
+		}
+	}
+	return names.String()
+}
+
+func TestPprofTrials(t *testing.T) {
+	defer runtime.SetMutexProfileFraction(runtime.SetMutexProfileFraction(7))
+	prefix := filepath.Join(t.TempDir(), "bench")
+	r := quietRunner(t)
+	r.Trials = 2
+	r.Pprof = kebench.Pprof{Kinds: []string{"heap", "mutex", "block"}, Path: prefix}
+	if _, err := r.Run(context.Background(), &profiledUnit{}, 2, 100); nil != err {
+		t.Fatal(err)
+	}
+	if fraction := runtime.SetMutexProfileFraction(-1); 7 != fraction {
+		t.Errorf("mutex profile fraction %d, want it restored to 7", fraction)
+	}
+	for _, kind := range []string{"block", "heap"} {
+		first := functions(t, prefix+".1."+kind+".pprof")
+		second := functions(t, prefix+".2."+kind+".pprof")
+		if !strings.Contains(first, "blockFirstTrial") || strings.Contains(first, "blockSecondTrial") {
+			t.Errorf("first %s profile:\n%s", kind, first)
+		}
+		if !strings.Contains(second, "blockSecondTrial") || strings.Contains(second, "blockFirstTrial") {
+			t.Errorf("second %s profile:\n%s", kind, second)
+		}
+	}
+}
+
+func TestPprofEarlyStop(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	target := httptest.NewServer(mux)
+	defer target.Close()
+
+	prefix := filepath.Join(t.TempDir(), "bench")
+	r := quietRunner(t)
+	r.Pprof = kebench.Pprof{Kinds: []string{"heap", "goroutine"}, Target: target.URL + "/debug/pprof", Path: prefix}
+	begin := time.Now()
+	rep, err := r.RunLoad(context.Background(), &countUnit{}, kebench.Load{Concurrency: 2, Total: 10, Duration: 5 * time.Second})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the total ended the bench, the target is not waited for
+	if cost := time.Since(begin); cost > 2*time.Second {
+		t.Errorf("bench of %v took %v", rep.Wall, cost)
+	}
+	want := []string{"goroutine", "heap", "target.goroutine"}
+	if len(want) != len(rep.Profiles) {
+		t.Fatalf("profiles %v", rep.Profiles)
+	}
+	for i, name := range want {
+		if path := prefix + "." + name + ".pprof"; path != rep.Profiles[i] {
+			t.Errorf("profile %d %s, want %s", i, rep.Profiles[i], path)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package kebench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// livePercentiles are the percentiles of the line printed per interval.
+var livePercentiles = []float64{0.5, 0.99}
+
+// tally is what a report needs to know of a group of requests.
+type tally struct {
+	latency *Histogram
+	errors  int64
+	types   map[string]int64
+}
+
+func newTally(precision int) tally {
+	return tally{latency: NewHistogram(precision)}
+}
+
+func (t *tally) record(latency int64, err error) {
+	t.latency.Record(latency)
+	if nil != err {
+		t.errors++
+		if nil == t.types {
+			t.types = map[string]int64{}
+		}
+		t.types[err.Error()]++
+	}
+}
+
+func (t *tally) merge(o tally) {
+	if nil == o.latency {
+		return
+	}
+	if nil == t.latency {
+		t.latency = &Histogram{bits: o.latency.bits}
+	}
+	t.latency.Merge(o.latency)
+	t.errors += o.errors
+	for name, n := range o.types {
+		if nil == t.types {
+			t.types = map[string]int64{}
+		}
+		t.types[name] += n
+	}
+}
+
+func (t *tally) reset() {
+	t.latency.Reset()
+	t.errors = 0
+	t.types = nil
+}
+
+func (t tally) count() int64 {
+	if nil == t.latency {
+		return 0
+	}
+	return t.latency.Count()
+}
+
+// recorder is what one worker records its requests into. Only the interval
+// in progress is shared, with the timeline that closes it, the recorders
+// of all workers are merged once the phase is over.
+type recorder struct {
+	precision int
+	all       tally
+	// service and queue split the latency of open-loop requests
+	service *Histogram
+	queue   *Histogram
+	byOp    []tally
+	byStage []tally
+	// current is the interval in progress, handed over to the timeline
+	// once the worker records past it or the timeline closes it
+	timeline *timeline
+	mtx      sync.Mutex
+	interval int
+	current  tally
+}
+
+func newRecorder(precision int, p plan, series *timeline) *recorder {
+	rec := &recorder{
+		precision: precision,
+		all:       newTally(precision),
+		byStage:   make([]tally, len(p.stages)),
+		timeline:  series,
+		current:   newTally(precision),
+	}
+	if p.open() {
+		rec.service, rec.queue = NewHistogram(precision), NewHistogram(precision)
+	}
+	if nil != series {
+		series.watched(rec)
+	}
+	return rec
+}
+
+func (rec *recorder) record(entry RecordEntry) {
+	latency := entry.Latency()
+	rec.all.record(latency, entry.Err)
+	if nil != rec.service {
+		rec.service.Record(entry.Cost)
+		rec.queue.Record(entry.Wait)
+	}
+	for len(rec.byOp) <= entry.Op {
+		rec.byOp = append(rec.byOp, newTally(rec.precision))
+	}
+	rec.byOp[entry.Op].record(latency, entry.Err)
+	stage := &rec.byStage[entry.Stage]
+	if nil == stage.latency {
+		*stage = newTally(rec.precision)
+	}
+	stage.record(latency, entry.Err)
+	if nil == rec.timeline {
+		return
+	}
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if i := rec.timeline.index(entry.At); i != rec.interval {
+		rec.handOver()
+		rec.interval = i
+	}
+	rec.current.record(latency, entry.Err)
+}
+
+// flush hands the interval in progress over to the timeline.
+func (rec *recorder) flush() {
+	if nil == rec.timeline {
+		return
+	}
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	rec.handOver()
+}
+
+// close hands the interval in progress over when it is i or earlier, for
+// a worker that may not record another request for a while.
+func (rec *recorder) close(i int) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if rec.interval <= i {
+		rec.handOver()
+	}
+}
+
+// handOver passes current to the timeline, rec.mtx held.
+func (rec *recorder) handOver() {
+	if 0 == rec.current.count() {
+		return
+	}
+	rec.timeline.add(rec.interval, rec.current)
+	rec.current.reset()
+}
+
+// merge adds the records of o, which must have been made for the same plan.
+func (rec *recorder) merge(o *recorder) {
+	rec.all.merge(o.all)
+	if nil != rec.service {
+		rec.service.Merge(o.service)
+		rec.queue.Merge(o.queue)
+	}
+	for len(rec.byOp) < len(o.byOp) {
+		rec.byOp = append(rec.byOp, newTally(rec.precision))
+	}
+	for i, t := range o.byOp {
+		rec.byOp[i].merge(t)
+	}
+	for i, t := range o.byStage {
+		rec.byStage[i].merge(t)
+	}
+}
+
+// records are what the recorder holds, sliced into the intervals of timed
+// when it is not nil.
+func (rec *recorder) records(timed *timeline) Records {
+	records := Records{
+		all:     rec.all,
+		service: rec.service,
+		queue:   rec.queue,
+		byOp:    rec.byOp,
+		byStage: rec.byStage,
+	}
+	if nil != timed {
+		records.intervals = timed.tallies
+	}
+	return records
+}
+
+// timeline collects the tallies of fixed width intervals, counted by
+// completion time from the start of the phase. Workers lock it once per
+// interval they took part in, not per request, and the intervals printed
+// live are closed by collecting them from every recorder.
+type timeline struct {
+	width     int64
+	mtx       sync.Mutex
+	tallies   []tally
+	recorders []*recorder
+}
+
+func newTimeline(width time.Duration) *timeline {
+	if width <= 0 {
+		return nil
+	}
+	return &timeline{width: int64(width)}
+}
+
+func (s *timeline) index(at int64) int {
+	return int(max(at, 0) / s.width)
+}
+
+// watched makes rec one of the recorders an interval is collected from.
+func (s *timeline) watched(rec *recorder) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.recorders = append(s.recorders, rec)
+}
+
+// collect closes the i-th interval, whether the workers recorded past it
+// or not.
+func (s *timeline) collect(i int) {
+	s.mtx.Lock()
+	recorders := s.recorders
+	s.mtx.Unlock()
+	for _, rec := range recorders {
+		rec.close(i)
+	}
+}
+
+func (s *timeline) add(i int, t tally) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for len(s.tallies) <= i {
+		s.tallies = append(s.tallies, tally{})
+	}
+	s.tallies[i].merge(t)
+}
+
+// watch prints every interval half an interval after it is over, giving
+// the requests then running time to complete, until quit. It returns the
+// first interval it did not print.
+func (s *timeline) watch(start time.Time, quit *halt) int {
+	for i := 0; ; i++ {
+		due := start.Add(time.Duration(int64(i+1)*s.width + s.width/2))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-quit.done:
+			timer.Stop()
+			return i
+		case <-timer.C:
+		}
+		s.collect(i)
+		s.print(i, time.Duration(s.width))
+	}
+}
+
+// printFrom prints the intervals from the i-th on of a phase that ran
+// for wall, laid out as in the report.
+func (s *timeline) printFrom(i int, wall time.Duration) {
+	width := time.Duration(s.width)
+	n := spans(wall, width)
+	for ; i < n; i++ {
+		s.print(i, spanOf(i, n, wall, width))
+	}
+}
+
+func (s *timeline) print(i int, d time.Duration) {
+	in := Interval{Offset: time.Duration(int64(i) * s.width), Duration: d}
+	s.mtx.Lock()
+	var t tally
+	if i < len(s.tallies) {
+		t = s.tallies[i]
+	}
+	for j := i + 1; j < len(s.tallies); j++ {
+		// the tail of the phase folded into its last interval
+		t.merge(s.tallies[j])
+	}
+	in.summarize(t, d, livePercentiles, NearestRank)
+	s.mtx.Unlock()
+	in.print()
+}
+
+func (in Interval) print() {
+	p50, _ := in.Latency.Percentile(0.5)
+	p99, _ := in.Latency.Percentile(0.99)
+	fmt.Printf("[%v-%v] requests: %d, TPS: %.2f, P50: %v, P99: %v, Max: %v, errors: %d\n",
+		in.Offset, in.Offset+in.Duration, in.Requests, in.TPS, p50, p99, in.Latency.Max, in.Errors)
+}
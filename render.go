@@ -0,0 +1,325 @@
+package kebench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportFormat renders rep into w. The Write methods of Report are all
+// ReportFormats as method expressions, e.g. (*Report).WriteJSON.
+type ReportFormat func(rep *Report, w io.Writer) error
+
+var (
+	formatsMtx sync.RWMutex
+	formats    = map[string]ReportFormat{
+		"text": (*Report).WriteText,
+		"json": (*Report).WriteJSON,
+		"csv":  (*Report).WriteCSV,
+		"md":   (*Report).WriteMarkdown,
+		"html": (*Report).WriteHTML,
+	}
+)
+
+// RegisterFormat makes format available to Outputs under name, replacing
+// any format registered under it before.
+func RegisterFormat(name string, format ReportFormat) {
+	formatsMtx.Lock()
+	defer formatsMtx.Unlock()
+	formats[name] = format
+}
+
+// LookupFormat returns the format registered under name.
+func LookupFormat(name string) (ReportFormat, bool) {
+	formatsMtx.RLock()
+	defer formatsMtx.RUnlock()
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Output is one place a finished report is written to: a registered
+// format name and a file path, stdout when the path is empty or "-".
+type Output struct {
+	Format string
+	Path   string
+}
+
+// ParseOutput reads an output written as format=path, or just format
+// for stdout, e.g. json=out.json.
+func ParseOutput(spec string) (Output, error) {
+	format, path, _ := strings.Cut(spec, "=")
+	if _, ok := LookupFormat(format); !ok {
+		return Output{}, fmt.Errorf("%w: %q", ErrFormat, format)
+	}
+	return Output{Format: format, Path: path}, nil
+}
+
+// Write renders rep to the output.
+func (o Output) Write(rep *Report) error {
+	format, ok := LookupFormat(o.Format)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrFormat, o.Format)
+	}
+	if "" == o.Path || "-" == o.Path {
+		return format(rep, os.Stdout)
+	}
+	f, err := os.Create(o.Path)
+	if nil != err {
+		return err
+	}
+	if err = format(rep, f); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// output writes rep to every output of the Runner, reporting the first
+// failure after trying them all.
+func (r *Runner) output(rep *Report) error {
+	var first error
+	for _, o := range r.Outputs {
+		if err := o.Write(rep); nil != err && nil == first {
+			first = err
+		}
+	}
+	return first
+}
+
+// WriteJSON renders the report as indented JSON, durations in
+// nanoseconds.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+func (e WorkerError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Worker int
+		Setup  bool
+		Err    string
+	}{e.Worker, e.Setup, e.Err.Error()})
+}
+
+func (e *WorkerError) UnmarshalJSON(data []byte) error {
+	var w struct {
+		Worker int
+		Setup  bool
+		Err    string
+	}
+	if err := json.Unmarshal(data, &w); nil != err {
+		return err
+	}
+	e.Worker, e.Setup, e.Err = w.Worker, w.Setup, errors.New(w.Err)
+	return nil
+}
+
+// intervalRows is the series the CSV renderer writes, the whole bench as
+// a single interval when the report has no time series.
+func (rep *Report) intervalRows() []Interval {
+	if 0 != len(rep.Intervals) {
+		return rep.Intervals
+	}
+	return []Interval{{
+		Duration: rep.Wall,
+		Summary: Summary{
+			Requests:   rep.Requests,
+			Errors:     rep.Errors,
+			ErrorRate:  rep.ErrorRate,
+			TPS:        rep.TPS,
+			Latency:    rep.Latency,
+			ErrorTypes: rep.ErrorTypes,
+		},
+	}}
+}
+
+// WriteCSV renders one row per interval, durations in nanoseconds.
+func (rep *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"offset_ns", "duration_ns", "requests", "errors", "error_rate", "tps", "min_ns", "mean_ns", "max_ns"}
+	for _, q := range rep.Latency.Percentiles {
+		header = append(header, "p"+strconv.FormatFloat(q.Q*100, 'g', -1, 64)+"_ns")
+	}
+	if nil != rep.Host {
+		header = append(header, "cpu_pct", "memory_pct", "load1", "context_switches_per_s", "net_sent_bps", "net_recv_bps")
+	}
+	cw.Write(header)
+	for _, in := range rep.intervalRows() {
+		row := []string{
+			strconv.FormatInt(int64(in.Offset), 10),
+			strconv.FormatInt(int64(in.Duration), 10),
+			strconv.FormatInt(in.Requests, 10),
+			strconv.FormatInt(in.Errors, 10),
+			strconv.FormatFloat(in.ErrorRate, 'f', 6, 64),
+			strconv.FormatFloat(in.TPS, 'f', 2, 64),
+			strconv.FormatInt(int64(in.Latency.Min), 10),
+			strconv.FormatInt(int64(in.Latency.Mean), 10),
+			strconv.FormatInt(int64(in.Latency.Max), 10),
+		}
+		for i := range rep.Latency.Percentiles {
+			var v time.Duration
+			if i < len(in.Latency.Percentiles) {
+				v = in.Latency.Percentiles[i].Value
+			}
+			row = append(row, strconv.FormatInt(int64(v), 10))
+		}
+		if nil != rep.Host {
+			var h HostSample
+			if nil != in.Host {
+				h = *in.Host
+			}
+			for _, v := range []float64{h.CPU, h.Memory, h.Load1, h.ContextSwitches, h.NetSent, h.NetRecv} {
+				row = append(row, strconv.FormatFloat(v, 'f', 2, 64))
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown renders the report as tables to paste into a PR comment.
+func (rep *Report) WriteMarkdown(w io.Writer) error {
+	t := &textWriter{w: w}
+	if rep.Partial {
+		t.printf("> **Partial report**: the bench was canceled, only completed requests are counted.\n\n")
+	}
+	t.printf("| Metric | Value |\n|---|---|\n")
+	t.printf("| Requests | %d |\n", rep.Requests)
+	t.printf("| Errors | %d (%.2f%%) |\n", rep.Errors, rep.ErrorRate*100)
+	t.printf("| Wall Time | %v |\n", rep.Wall)
+	if 0 != rep.Rate {
+		t.printf("| Offered Rate | %.2f |\n", rep.Rate)
+	}
+	t.printf("| TPS | %.2f |\n", rep.TPS)
+	t.printf("| Min | %v |\n| Mean | %v |\n| Max | %v |\n| StdDev | %v |\n",
+		rep.Latency.Min, rep.Latency.Mean, rep.Latency.Max, rep.Latency.StdDev)
+	for _, q := range rep.Latency.Percentiles {
+		t.printf("| P%g | %v |\n", q.Q*100, q.Value)
+	}
+
+	if nil != rep.Service {
+		t.printf("\n| Percentile | Latency | Service | Queue |\n|---|---|---|---|\n")
+		for i, q := range rep.Latency.Percentiles {
+			t.printf("| P%g | %v | %v | %v |\n", q.Q*100, q.Value,
+				rep.Service.Percentiles[i].Value, rep.Queue.Percentiles[i].Value)
+		}
+	}
+	if 0 != len(rep.ErrorTypes) {
+		t.printf("\n| Error | Count |\n|---|---|\n")
+		for _, name := range sortedKeys(rep.ErrorTypes) {
+			t.printf("| %s | %d |\n", markdownEscape(name), rep.ErrorTypes[name])
+		}
+	}
+	if 0 != len(rep.Operations) {
+		t.printf("\n| Operation ")
+		writeMarkdownSummaryHeader(t, rep.Latency.Percentiles)
+		for _, op := range rep.Operations {
+			t.printf("| %s ", markdownEscape(op.Name))
+			writeMarkdownSummary(t, op.Summary)
+		}
+	}
+	if 0 != len(rep.Stages) {
+		t.printf("\n| Stage ")
+		writeMarkdownSummaryHeader(t, rep.Latency.Percentiles)
+		for i, stage := range rep.Stages {
+			t.printf("| %d (%v, c%d", i+1, stage.Stage.Duration, stage.Stage.Concurrency)
+			if stage.Stage.Rate > 0 {
+				t.printf(", r%.2f", stage.Stage.Rate)
+			}
+			if stage.Stage.Ramp {
+				t.printf(", ramp")
+			}
+			t.printf(") ")
+			writeMarkdownSummary(t, stage.Summary)
+		}
+	}
+	if nil != rep.Trials {
+		t.printf("\n| Trial | Wall | Requests | Errors | TPS |")
+		for _, e := range rep.Trials.Percentiles {
+			t.printf(" P%g |", e.Q*100)
+		}
+		t.printf(" Outlier |\n|---|---|---|---|---|")
+		for range rep.Trials.Percentiles {
+			t.printf("---|")
+		}
+		t.printf("---|\n")
+		for i, run := range rep.Trials.Runs {
+			t.printf("| %d | %v | %d | %d | %.2f |", i+1, run.Wall, run.Requests, run.Errors, run.TPS)
+			for _, q := range run.Latency {
+				t.printf(" %v |", q.Value)
+			}
+			t.printf(" %s |\n", strings.Join(run.Outlier, " "))
+		}
+		t.printf("| %g%% CI | | | | %.2f ± %.2f |", rep.Trials.Confidence*100, rep.Trials.TPS.Mean, rep.Trials.TPS.Margin)
+		for _, e := range rep.Trials.Percentiles {
+			t.printf(" %v ± %v |", time.Duration(e.Mean), time.Duration(e.Margin))
+		}
+		t.printf(" |\n")
+	}
+	if nil != rep.Host {
+		h := rep.Host
+		t.printf("\n| Host (%d samples) | Min | Mean | Max |\n|---|---|---|---|\n", h.Samples)
+		for _, row := range []struct {
+			name  string
+			gauge Gauge
+		}{
+			{"CPU %", h.CPU},
+			{"Memory %", h.Memory},
+			{"Load 1m", h.Load1},
+			{"Context Switches/s", h.ContextSwitches},
+			{"Net Sent B/s", h.NetSent},
+			{"Net Recv B/s", h.NetRecv},
+		} {
+			t.printf("| %s | %.2f | %.2f | %.2f |\n", row.name, row.gauge.Min, row.gauge.Mean, row.gauge.Max)
+		}
+	}
+	if nil != rep.Runtime {
+		rt := rep.Runtime
+		t.printf("\n| Runtime | Value |\n|---|---|\n")
+		t.printf("| B/op | %.0f |\n| allocs/op | %.1f |\n", rt.BytesPerOp, rt.AllocsPerOp)
+		t.printf("| GC Cycles | %d |\n| GC Pause Total | %v |\n| GC Pause Max | %v |\n", rt.GCCycles, rt.GCPause.Sum, rt.GCPause.Max)
+		t.printf("| Goroutines | %.0f to %.0f |\n", rt.Goroutines.Min, rt.Goroutines.Max)
+		if d, ok := rt.SchedLatency.Percentile(0.99); ok {
+			t.printf("| Sched Latency P99 | %v |\n", d)
+		}
+	}
+	if nil != rep.Clock {
+		c := rep.Clock
+		t.printf("\n| Clock | Value |\n|---|---|\n")
+		t.printf("| Resolution | %v |\n| Error Bound | %v |\n| Coarse | %t |\n", c.Accuracy.Resolution, c.Accuracy.ErrorBound, c.Coarse)
+	}
+	return t.err
+}
+
+func writeMarkdownSummaryHeader(t *textWriter, percentiles []Quantile) {
+	t.printf("| Requests | Errors | TPS | Mean |")
+	for _, q := range percentiles {
+		t.printf(" P%g |", q.Q*100)
+	}
+	t.printf("\n|---|---|---|---|---|")
+	for range percentiles {
+		t.printf("---|")
+	}
+	t.printf("\n")
+}
+
+func writeMarkdownSummary(t *textWriter, s Summary) {
+	t.printf("| %d | %d | %.2f | %v |", s.Requests, s.Errors, s.TPS, s.Latency.Mean)
+	for _, q := range s.Latency.Percentiles {
+		t.printf(" %v |", q.Value)
+	}
+	t.printf("\n")
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
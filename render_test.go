@@ -0,0 +1,121 @@
+package kebench_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func benchReport(t *testing.T) *kebench.Report {
+	r := quietRunner(t)
+	rep, err := r.Run(context.Background(), &flakyUnit{}, 2, 90)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return rep
+}
+
+func TestWriteJSON(t *testing.T) {
+	rep := benchReport(t)
+	var buf bytes.Buffer
+	if err := rep.WriteJSON(&buf); nil != err {
+		t.Fatal(err)
+	}
+	var back kebench.Report
+	if err := json.Unmarshal(buf.Bytes(), &back); nil != err {
+		t.Fatal(err)
+	}
+	if back.Requests != rep.Requests || back.Latency.Max != rep.Latency.Max || 30 != back.ErrorTypes["flaky"] {
+		t.Errorf("round trip %+v", back)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rep := benchReport(t)
+	var buf bytes.Buffer
+	if err := rep.WriteCSV(&buf); nil != err {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != len(rows) || "requests" != rows[0][2] || "90" != rows[1][2] {
+		t.Errorf("rows %v", rows)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	rep := benchReport(t)
+	var buf bytes.Buffer
+	if err := rep.WriteMarkdown(&buf); nil != err {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"| Requests | 90 |", "| flaky | 30 |", "| P99 |"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("markdown misses %q", want)
+		}
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	r := quietRunner(t)
+	r.Interval = 20 * time.Millisecond
+	rep, err := r.RunFor(context.Background(), &countUnit{sleep: time.Millisecond}, 2, 100*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(rep.Intervals) < 2 || 0 == len(rep.Histogram) || 0 == len(rep.Curve) {
+		t.Fatalf("intervals %d, buckets %d, curve %d", len(rep.Intervals), len(rep.Histogram), len(rep.Curve))
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteHTML(&buf); nil != err {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "<svg"); 5 != n {
+		t.Errorf("%d charts", n)
+	}
+	for _, want := range []string{"<th>Timeout</th><td>1s</td>", "Latency histogram", "TPS over time"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("html misses %q", want)
+		}
+	}
+	if strings.Contains(out, "src=") || strings.Contains(out, "href=") {
+		t.Error("html references external assets")
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	o, err := kebench.ParseOutput("json=" + path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := o.Write(benchReport(t)); nil != err {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); nil != err || !json.Valid(data) {
+		t.Errorf("output file %q, %v", data, err)
+	}
+	if _, err := kebench.ParseOutput("yaml=out.yaml"); nil == err {
+		t.Error("unknown format accepted")
+	}
+
+	kebench.RegisterFormat("count", func(rep *kebench.Report, w io.Writer) error {
+		_, err := io.WriteString(w, "counted")
+		return err
+	})
+	if _, err := kebench.ParseOutput("count"); nil != err {
+		t.Error(err)
+	}
+}
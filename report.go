@@ -0,0 +1,431 @@
+package kebench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPercentiles are the quantiles reported by a new Runner.
+var DefaultPercentiles = []float64{0.1, 0.3, 0.5, 0.7, 0.8, 0.9, 0.99, 0.999, 0.9999}
+
+// PercentileMethod selects how percentiles are read off the latencies.
+type PercentileMethod int
+
+const (
+	// NearestRank takes the smallest latency that at least the fraction q
+	// of the requests did not exceed, a recorded value within the
+	// precision of the latency histogram.
+	NearestRank PercentileMethod = iota
+	// Interpolated interpolates linearly between the two closest ranks,
+	// the default of numpy and R.
+	Interpolated
+)
+
+func (m PercentileMethod) String() string {
+	switch m {
+	case NearestRank:
+		return "nearest-rank"
+	case Interpolated:
+		return "interpolated"
+	}
+	return "method(" + strconv.Itoa(int(m)) + ")"
+}
+
+// ParsePercentileMethod reads a method by its String.
+func ParsePercentileMethod(name string) (PercentileMethod, error) {
+	for _, m := range []PercentileMethod{NearestRank, Interpolated} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrPercentileMethod, name)
+}
+
+// DefaultInterval is the time series resolution of a new Runner.
+const DefaultInterval = time.Second
+
+// histogramBuckets is how many log-spaced buckets Report.Histogram has.
+const histogramBuckets = 50
+
+// Report is the outcome of a bench. Latency is measured from the intended
+// start of a request, which for open-loop loads includes the time it sat
+// queued; Service and Queue split it up for those and are nil otherwise.
+// Rate is the offered rate of a single-stage open-loop bench.
+//
+// Distribution holds every latency at the Runner's Precision, Histogram
+// and Curve are drawn from it. Intervals slice the bench by completion
+// time at the Runner's Interval. Trials is set when the Runner repeated
+// the bench, the rest of the report then counts all trials together.
+// Host is the load on the machine running the bench, nil when the Runner
+// did not sample it, Runtime what the Go runtime of the Runner did and
+// Profiles the pprof files captured meanwhile. Clock is set when the
+// Runner's clock knows its accuracy, such as a CachedClock.
+type Report struct {
+	Params       Params
+	Requests     int64
+	Errors       int64
+	ErrorRate    float64
+	Wall         time.Duration
+	TPS          float64
+	Rate         float64
+	Latency      Stats
+	Service      *Stats
+	Queue        *Stats
+	ErrorTypes   map[string]int64
+	Distribution *Histogram
+	Histogram    []Bucket
+	Curve        []Quantile
+	Intervals    []Interval
+	Operations   []OperationReport
+	Stages       []StageReport
+	WarmUp       *WarmUpSummary
+	Trials       *TrialSummary
+	Host         *HostReport
+	Runtime      *RuntimeReport
+	Profiles     []string
+	Clock        *ClockReport
+	Leaks        LeakStats
+	Workers      []WorkerError
+	// Partial is set when the bench was cancelled, Limited when the
+	// abandoned handler limit ended it early.
+	Partial bool
+	Limited bool
+}
+
+// Params are the settings a bench ran with.
+type Params struct {
+	Start    time.Time
+	Stages   []Stage
+	Total    int64
+	Arrival  Arrival
+	Timeout  time.Duration
+	Interval time.Duration
+	Method   PercentileMethod
+}
+
+func (r *Runner) params(p plan, start time.Time) Params {
+	return Params{
+		Start:    start,
+		Stages:   p.stages,
+		Total:    p.total,
+		Arrival:  p.arrival,
+		Timeout:  r.Timeout,
+		Interval: r.Interval,
+		Method:   r.PercentileMethod,
+	}
+}
+
+// Interval is the share of the bench between Offset and Offset+Duration.
+// Host is the last host sample taken within it.
+type Interval struct {
+	Offset   time.Duration
+	Duration time.Duration
+	Summary
+	Host *HostSample
+}
+
+// Bucket counts the requests slower than the previous bucket's Upper and
+// at most as slow as its own.
+type Bucket struct {
+	Upper time.Duration
+	Count int64
+}
+
+// Summary is what a report gives for a subset of the requests.
+type Summary struct {
+	Requests   int64
+	Errors     int64
+	ErrorRate  float64
+	TPS        float64
+	Latency    Stats
+	ErrorTypes map[string]int64
+}
+
+func (s *Summary) summarize(t tally, wall time.Duration, percentiles []float64, method PercentileMethod) {
+	s.Requests = t.count()
+	s.Errors = t.errors
+	s.ErrorTypes = make(map[string]int64, len(t.types))
+	for name, n := range t.types {
+		s.ErrorTypes[name] = n
+	}
+	if 0 != s.Requests {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+	}
+	if wall > 0 {
+		s.TPS = float64(s.Requests) / wall.Seconds()
+	}
+	s.Latency = t.latency.stats(percentiles, method)
+}
+
+// OperationReport is the share of one scenario operation.
+type OperationReport struct {
+	Name string
+	Summary
+}
+
+// StageReport is the share of one profile stage. Window is how long the
+// stage ran, its scheduled duration unless the bench ended early.
+type StageReport struct {
+	Stage  Stage
+	Window time.Duration
+	Summary
+}
+
+// Stats summarises a latency distribution.
+type Stats struct {
+	Count       int64
+	Min         time.Duration
+	Max         time.Duration
+	Mean        time.Duration
+	StdDev      time.Duration
+	Sum         time.Duration
+	Percentiles []Quantile
+}
+
+// Quantile is the latency at or below which the fraction Q of requests
+// completed.
+type Quantile struct {
+	Q     float64
+	Value time.Duration
+}
+
+// Percentile looks up the latency at q, which must be one of the
+// percentiles the report was built with.
+func (s Stats) Percentile(q float64) (time.Duration, bool) {
+	for _, p := range s.Percentiles {
+		if p.Q == q {
+			return p.Value, true
+		}
+	}
+	return 0, false
+}
+
+// curveQuantiles are the points of a percentile curve over n values,
+// spaced evenly in the number of nines and stopping where n no longer
+// resolves them.
+func curveQuantiles(n int64) []float64 {
+	var qs []float64
+	for k := 1; ; k++ {
+		tail := math.Pow(10, -float64(k)/10)
+		if tail*float64(n) < 1 {
+			return qs
+		}
+		qs = append(qs, 1-tail)
+	}
+}
+
+// intervals lays the tallies of a timeline of the given width out over
+// wall, as spans does.
+func intervals(tallies []tally, wall, width time.Duration, percentiles []float64, method PercentileMethod) []Interval {
+	if width <= 0 || wall <= 0 {
+		return nil
+	}
+	n := spans(wall, width)
+	merged := make([]tally, n)
+	for i, t := range tallies {
+		// requests completing after the end was taken count to the last
+		merged[min(i, n-1)].merge(t)
+	}
+	series := make([]Interval, n)
+	for i := range series {
+		series[i].Offset = time.Duration(i) * width
+		series[i].Duration = spanOf(i, n, wall, width)
+		series[i].summarize(merged[i], series[i].Duration, percentiles, method)
+	}
+	return series
+}
+
+// spans is how many intervals of width wall is laid out in. A tail shorter
+// than half an interval is folded into the last one rather than left to
+// make a rate of a few requests over a moment.
+func spans(wall, width time.Duration) int {
+	n := int(wall / width)
+	if rest := wall % width; 0 == n || (0 != rest && rest >= width/2) {
+		n++
+	}
+	return n
+}
+
+// spanOf is the duration of the i-th of n intervals laid out over wall,
+// the last one running to the end of the bench.
+func spanOf(i, n int, wall, width time.Duration) time.Duration {
+	if i == n-1 {
+		return wall - time.Duration(i)*width
+	}
+	return width
+}
+
+func validatePercentiles(percentiles []float64, method PercentileMethod) error {
+	if NearestRank != method && Interpolated != method {
+		return ErrPercentileMethod
+	}
+	for _, q := range percentiles {
+		if q <= 0 || q > 1 {
+			return ErrPercentile
+		}
+	}
+	return nil
+}
+
+func (r *Runner) report(records Records, p plan) *Report {
+	wall := records.wall
+	report := &Report{
+		Params:  records.params,
+		WarmUp:  records.warm,
+		Wall:    wall,
+		Leaks:   records.leak,
+		Workers: records.workers,
+		Partial: records.partial,
+		Limited: records.limited,
+	}
+	var all Summary
+	all.summarize(records.all, wall, r.Percentiles, r.PercentileMethod)
+	report.Requests = all.Requests
+	report.Errors = all.Errors
+	report.ErrorRate = all.ErrorRate
+	report.TPS = all.TPS
+	report.Latency = all.Latency
+	report.ErrorTypes = all.ErrorTypes
+	report.Distribution = records.all.latency
+	report.Curve = records.all.latency.stats(curveQuantiles(report.Requests), r.PercentileMethod).Percentiles
+	report.Histogram = records.all.latency.rebin(histogramBuckets)
+	report.Intervals = intervals(records.intervals, wall, r.Interval, r.Percentiles, r.PercentileMethod)
+	report.Host = hostReport(records.host)
+	report.Profiles = records.profiles
+	report.Clock = clockReport(records.clock, time.Duration(records.all.latency.ValueAt(0.5)))
+	if nil != records.runtime {
+		report.Runtime = records.runtime.report(report.Requests, r.Percentiles, r.PercentileMethod)
+	}
+	hostIntervals(report.Intervals, records.host)
+	if p.open() {
+		if 1 == len(p.stages) {
+			report.Rate = p.stages[0].Rate
+		}
+		// split the latency measured from the intended start into its parts
+		serviceStats := records.service.stats(r.Percentiles, r.PercentileMethod)
+		queueStats := records.queue.stats(r.Percentiles, r.PercentileMethod)
+		report.Service, report.Queue = &serviceStats, &queueStats
+	}
+	if 0 != len(records.ops) {
+		report.Operations = operationReports(records, wall, r.Percentiles, r.PercentileMethod)
+	}
+	if 1 < len(p.stages) {
+		report.Stages = stageReports(records, p, r.Percentiles, r.PercentileMethod)
+	}
+	return report
+}
+
+// textWriter keeps the first write error so renderers can print freely.
+type textWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *textWriter) printf(format string, args ...any) {
+	if nil == t.err {
+		_, t.err = fmt.Fprintf(t.w, format, args...)
+	}
+}
+
+// WriteText renders the report as the plain text the Runner prints.
+func (rep *Report) WriteText(w io.Writer) error {
+	t := &textWriter{w: w}
+	t.printf("bench cost %v\n", rep.Wall)
+	if rep.Partial {
+		t.printf("PARTIAL REPORT: the bench was canceled, only completed requests are counted\n")
+	}
+	for _, failure := range rep.Workers {
+		t.printf("%s\n", failure.Error())
+	}
+	t.printf("Total Requests: %d\n", rep.Requests)
+	if 0 != rep.Requests {
+		t.printf("Total Cost: %v\n", rep.Wall)
+		t.printf("Total Sum : %dns, %v\n", int64(rep.Latency.Sum), rep.Latency.Sum)
+		t.printf("Average Cost: %d, %v\n", int64(rep.Latency.Mean), rep.Latency.Mean)
+		t.printf("Min Cost: %v, Max Cost: %v, StdDev: %v\n", rep.Latency.Min, rep.Latency.Max, rep.Latency.StdDev)
+		if 0 != len(rep.ErrorTypes) {
+			t.printf("Error Types:\n")
+			for _, name := range sortedKeys(rep.ErrorTypes) {
+				t.printf("%s: %d\n", name, rep.ErrorTypes[name])
+			}
+		}
+		if 0 != rep.Errors {
+			t.printf("Error Rate: %.2f%%\n", rep.ErrorRate*100)
+		}
+		if 0 != rep.Rate {
+			t.printf("Offered Rate: %.2f\n", rep.Rate)
+		}
+		t.printf("TPS: %.2f\n", rep.TPS)
+		writeQuantiles(t, "Cost", rep.Latency)
+		if nil != rep.Service {
+			writeQuantiles(t, "Service", *rep.Service)
+			writeQuantiles(t, "Queue", *rep.Queue)
+		}
+		for _, op := range rep.Operations {
+			t.printf("Operation %s: ", op.Name)
+			writeSummary(t, op.Summary)
+		}
+		for i, stage := range rep.Stages {
+			t.printf("Stage %d (%v, concurrency %d", i+1, stage.Stage.Duration, stage.Stage.Concurrency)
+			if stage.Stage.Rate > 0 {
+				t.printf(", rate %.2f", stage.Stage.Rate)
+			}
+			if stage.Stage.Ramp {
+				t.printf(", ramp")
+			}
+			t.printf("): ")
+			writeSummary(t, stage.Summary)
+		}
+	}
+	if nil != rep.Trials {
+		rep.Trials.write(t)
+	}
+	if nil != rep.Host {
+		rep.Host.write(t)
+	}
+	if nil != rep.Runtime {
+		rep.Runtime.write(t)
+	}
+	if 0 != len(rep.Profiles) {
+		t.printf("Profiles: %s\n", strings.Join(rep.Profiles, " "))
+	}
+	if nil != rep.Clock {
+		rep.Clock.write(t)
+	}
+	rep.Leaks.write(t)
+	if rep.Limited {
+		t.printf("Stopped early: abandoned handler limit reached\n")
+	}
+	return t.err
+}
+
+func writeQuantiles(t *textWriter, name string, stats Stats) {
+	for _, q := range stats.Percentiles {
+		t.printf("%s at %.2f%%: %d, %v\n", name, q.Q*100, int64(q.Value), q.Value)
+	}
+}
+
+func writeSummary(t *textWriter, s Summary) {
+	t.printf("Requests: %d, Errors: %d", s.Requests, s.Errors)
+	if 0 != s.Requests {
+		t.printf(", Error Rate: %.2f%%, TPS: %.2f, Average: %v", s.ErrorRate*100, s.TPS, s.Latency.Mean)
+		for _, q := range s.Latency.Percentiles {
+			t.printf(", P%g: %v", q.Q*100, q.Value)
+		}
+	}
+	t.printf("\n")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
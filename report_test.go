@@ -0,0 +1,120 @@
+package kebench_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+// flakyUnit fails every third request.
+type flakyUnit struct {
+	n int64
+}
+
+func (u *flakyUnit) WarmUp() error { return nil }
+func (u *flakyUnit) Begin() error  { return nil }
+func (u *flakyUnit) End() error    { return nil }
+
+func (u *flakyUnit) Run() error {
+	if 0 == atomic.AddInt64(&u.n, 1)%3 {
+		return errors.New("flaky")
+	}
+	return nil
+}
+
+func TestReport(t *testing.T) {
+	r := quietRunner(t)
+	r.Percentiles = []float64{0.5, 0.999}
+	rep, err := r.Run(context.Background(), &flakyUnit{}, 3, 300)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 300 != rep.Requests || 100 != rep.Errors || 100 != rep.ErrorTypes["flaky"] {
+		t.Errorf("requests %d errors %d types %v", rep.Requests, rep.Errors, rep.ErrorTypes)
+	}
+	if 2 != len(rep.Latency.Percentiles) {
+		t.Fatalf("percentiles %v", rep.Latency.Percentiles)
+	}
+	p50, ok := rep.Latency.Percentile(0.5)
+	if !ok || p50 < rep.Latency.Min || p50 > rep.Latency.Max {
+		t.Errorf("p50 %v outside [%v, %v]", p50, rep.Latency.Min, rep.Latency.Max)
+	}
+	if rep.TPS <= 0 || rep.Wall <= 0 {
+		t.Errorf("tps %v wall %v", rep.TPS, rep.Wall)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.WriteText(&buf); nil != err {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Total Requests: 300", "flaky: 100", "Cost at 99.90%"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text report misses %q", want)
+		}
+	}
+}
+
+func TestReportInvalidPercentile(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	r.Percentiles = []float64{1.5}
+	if _, err := r.Run(context.Background(), &flakyUnit{}, 1, 1); kebench.ErrPercentile != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrPercentile)
+	}
+}
+
+func TestReportInvalidPrecision(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	r.Precision = 0
+	if _, err := r.Run(context.Background(), &flakyUnit{}, 1, 1); kebench.ErrPrecision != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrPrecision)
+	}
+}
+
+func TestReportEmpty(t *testing.T) {
+	r := quietRunner(t)
+	path := filepath.Join(t.TempDir(), "bench.kebs")
+	r.Samples = kebench.SampleFile{Path: path}
+	if _, err := r.Run(context.Background(), &flakyUnit{}, 2, 10); nil != err {
+		t.Fatal(err)
+	}
+	// no request kept
+	rep, err := r.Reanalyze(path, func(kebench.RawSample) bool { return false })
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 != rep.Requests || len(r.Percentiles) != len(rep.Latency.Percentiles) || 0 != rep.Latency.Max {
+		t.Fatalf("empty report %+v", rep)
+	}
+	for _, name := range []string{"text", "json", "csv", "md", "html"} {
+		format, _ := kebench.LookupFormat(name)
+		if err := format(rep, io.Discard); nil != err {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestReportInterpolated(t *testing.T) {
+	r := quietRunner(t)
+	r.PercentileMethod = kebench.Interpolated
+	rep, err := r.Run(context.Background(), &flakyUnit{}, 1, 100)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, q := range rep.Latency.Percentiles {
+		if q.Value < rep.Latency.Min || q.Value > rep.Latency.Max {
+			t.Errorf("p%g %v outside [%v, %v]", q.Q*100, q.Value, rep.Latency.Min, rep.Latency.Max)
+		}
+	}
+	r.PercentileMethod = -1
+	if _, err := r.Run(context.Background(), &flakyUnit{}, 1, 1); kebench.ErrPercentileMethod != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrPercentileMethod)
+	}
+}
@@ -0,0 +1,690 @@
+package kebench_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+type countUnit struct {
+	runs, warms int64
+	ended       int32
+	sleep       time.Duration
+}
+
+func (u *countUnit) Begin() error { return nil }
+
+func (u *countUnit) End() error {
+	atomic.StoreInt32(&u.ended, 1)
+	return nil
+}
+
+func (u *countUnit) WarmUp() error {
+	atomic.AddInt64(&u.warms, 1)
+	if 0 != u.sleep {
+		time.Sleep(u.sleep)
+	}
+	return nil
+}
+
+func (u *countUnit) Run() error {
+	atomic.AddInt64(&u.runs, 1)
+	if 0 != u.sleep {
+		time.Sleep(u.sleep)
+	}
+	return nil
+}
+
+// quietRunner is a Runner that skips the warm-up and writes, prints and
+// samples nothing beyond what a test sets up.
+func quietRunner(t *testing.T) *kebench.Runner {
+	t.Helper()
+	r := kebench.NewRunner(time.Now)
+	r.WarmUp = kebench.WarmUp{}
+	r.Outputs = nil
+	r.Live = false
+	r.HostInterval = 0
+	return r
+}
+
+func TestRunTotal(t *testing.T) {
+	u := &countUnit{}
+	r := kebench.NewRunner(time.Now)
+	rep, err := r.Run(context.Background(), u, 4, 1000)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 1000 != u.runs || 1000 != rep.Requests {
+		t.Errorf("runs %d, reported %d, want 1000", u.runs, rep.Requests)
+	}
+}
+
+func TestRunFor(t *testing.T) {
+	u := &countUnit{sleep: time.Millisecond}
+	r := kebench.NewRunner(time.Now)
+	begin := time.Now()
+	if _, err := r.RunFor(context.Background(), u, 4, 200*time.Millisecond); nil != err {
+		t.Fatal(err)
+	}
+	if cost := time.Since(begin); cost > time.Second {
+		t.Errorf("run took %v", cost)
+	}
+	if 0 == u.runs {
+		t.Error("no runs")
+	}
+}
+
+func TestRunUnbounded(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	if _, err := r.RunLoad(context.Background(), &countUnit{}, kebench.Load{Concurrency: 1}); kebench.ErrUnbounded != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrUnbounded)
+	}
+}
+
+func TestRunOpenLoop(t *testing.T) {
+	u := &countUnit{}
+	r := kebench.NewRunner(time.Now)
+	begin := time.Now()
+	_, err := r.RunLoad(context.Background(), u, kebench.Load{
+		Concurrency: 2,
+		Total:       50,
+		Rate:        500,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// 50 requests at 500/s are spread over ~100ms
+	if cost := time.Since(begin); cost < 90*time.Millisecond {
+		t.Errorf("open loop finished in %v, not paced", cost)
+	}
+	if 50 != u.runs {
+		t.Errorf("runs %d, want 50", u.runs)
+	}
+}
+
+func TestRunOpenLoopPoisson(t *testing.T) {
+	u := &countUnit{sleep: 5 * time.Millisecond}
+	r := quietRunner(t)
+	_, err := r.RunLoad(context.Background(), u, kebench.Load{
+		Concurrency: 1,
+		Duration:    100 * time.Millisecond,
+		Rate:        1000,
+		Arrival:     kebench.ArrivalPoisson,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 0 == u.runs {
+		t.Error("no runs")
+	}
+}
+
+type inflightUnit struct {
+	countUnit
+	inflight, peak int64
+}
+
+func (u *inflightUnit) Run() error {
+	n := atomic.AddInt64(&u.inflight, 1)
+	defer atomic.AddInt64(&u.inflight, -1)
+	for {
+		peak := atomic.LoadInt64(&u.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&u.peak, peak, n) {
+			break
+		}
+	}
+	return u.countUnit.Run()
+}
+
+func TestRunProfile(t *testing.T) {
+	u := &inflightUnit{countUnit: countUnit{sleep: time.Millisecond}}
+	r := kebench.NewRunner(time.Now)
+	begin := time.Now()
+	rep, err := r.RunProfile(context.Background(), u, kebench.Profile{Stages: []kebench.Stage{
+		{Duration: 100 * time.Millisecond, Concurrency: 4, Ramp: true},
+		{Duration: 100 * time.Millisecond, Concurrency: 4},
+		{Duration: 100 * time.Millisecond, Concurrency: 8},
+		{Duration: 100 * time.Millisecond, Concurrency: 1, Ramp: true},
+	}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if cost := time.Since(begin); cost > 2*time.Second {
+		t.Errorf("profile took %v", cost)
+	}
+	if 8 != u.peak {
+		t.Errorf("peak concurrency %d, want 8", u.peak)
+	}
+	if 4 != len(rep.Stages) {
+		t.Fatalf("stages %d, want 4", len(rep.Stages))
+	}
+	var sum int64
+	for _, stage := range rep.Stages {
+		sum += stage.Requests
+	}
+	if sum != rep.Requests {
+		t.Errorf("stage requests %d, total %d", sum, rep.Requests)
+	}
+}
+
+func TestRunProfileInvalid(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	if _, err := r.RunProfile(context.Background(), &countUnit{}, kebench.Profile{}); kebench.ErrNoStages != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrNoStages)
+	}
+}
+
+type ctxUnit struct {
+	expired int64
+}
+
+func (u *ctxUnit) WarmUp(ctx context.Context) error { return nil }
+func (u *ctxUnit) Begin() error                     { return nil }
+func (u *ctxUnit) End() error                       { return nil }
+
+func (u *ctxUnit) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		atomic.AddInt64(&u.expired, 1)
+		return ctx.Err()
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestBenchTimeout(t *testing.T) {
+	u := &ctxUnit{}
+	r := kebench.NewRunner(time.Now)
+	r.Timeout = 10 * time.Millisecond
+	if _, err := r.Bench(context.Background(), u, kebench.Load{Concurrency: 2, Total: 10}); nil != err {
+		t.Fatal(err)
+	}
+	// give the abandoned handlers a moment to observe the deadline
+	time.Sleep(20 * time.Millisecond)
+	if 10 != atomic.LoadInt64(&u.expired) {
+		t.Errorf("expired %d, want 10", u.expired)
+	}
+}
+
+func TestRunAbandonedBackOff(t *testing.T) {
+	u := &inflightUnit{countUnit: countUnit{sleep: 30 * time.Millisecond}}
+	r := quietRunner(t)
+	r.Timeout = time.Millisecond
+	r.MaxAbandoned = 4
+	rep, err := r.Run(context.Background(), u, 4, 40)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 40 != atomic.LoadInt64(&u.runs) {
+		t.Errorf("runs %d, want 40", u.runs)
+	}
+	// every worker may start one more handler after the check passed
+	if peak := atomic.LoadInt64(&u.peak); peak > 8 {
+		t.Errorf("peak handlers %d, want at most 8", peak)
+	}
+	// every request timed out, all but the last few handlers returned
+	// while the workers backed off
+	leaks := rep.Leaks
+	if 40 != leaks.Abandoned || leaks.Late < 40-8 || leaks.Late > leaks.Abandoned || leaks.InFlight > 8 || leaks.Peak > 8 {
+		t.Errorf("leaks %+v", leaks)
+	}
+	if 40 != rep.Errors || 40 != rep.ErrorTypes[kebench.ErrTimeout.Error()] {
+		t.Errorf("errors %d %v, want 40 timeouts", rep.Errors, rep.ErrorTypes)
+	}
+}
+
+// slowWarmUnit hangs on to its warm-up handlers past their timeout.
+type slowWarmUnit struct {
+	countUnit
+}
+
+func (u *slowWarmUnit) WarmUp() error {
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+func TestRunAbandonedInWarmUp(t *testing.T) {
+	u := &slowWarmUnit{countUnit: countUnit{sleep: time.Millisecond}}
+	r := quietRunner(t)
+	r.WarmUp = kebench.WarmUp{Concurrency: 4, Total: 4}
+	r.Timeout = 20 * time.Millisecond
+	rep, err := r.RunFor(context.Background(), u, 2, 200*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the warm-up handlers land during the bench, which abandoned none
+	if leaks := rep.Leaks; 0 != leaks.Abandoned || 0 != leaks.Late {
+		t.Errorf("leaks %+v, want none of the bench", leaks)
+	}
+}
+
+func TestRunAbandonedOpenLoop(t *testing.T) {
+	u := &countUnit{sleep: 150 * time.Millisecond}
+	r := quietRunner(t)
+	r.Timeout = 5 * time.Millisecond
+	r.MaxAbandoned = 1
+	rep, err := r.RunLoad(context.Background(), u, kebench.Load{Concurrency: 1, Total: 3, Rate: 10})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// requests due while the first handler still ran were held back, and
+	// that counts as waiting
+	if nil == rep.Queue || rep.Queue.Max < 30*time.Millisecond {
+		t.Errorf("queue %+v, want the throttled time in it", rep.Queue)
+	}
+}
+
+func TestRunAbandonedStop(t *testing.T) {
+	u := &countUnit{sleep: 30 * time.Millisecond}
+	r := kebench.NewRunner(time.Now)
+	r.Timeout = time.Millisecond
+	r.MaxAbandoned = 2
+	r.StopOnAbandoned = true
+	if _, err := r.Run(context.Background(), u, 1, 1000); nil != err {
+		t.Fatal(err)
+	}
+	if runs := atomic.LoadInt64(&u.runs); runs >= 1000 {
+		t.Errorf("runs %d, want the limit to stop the bench", runs)
+	}
+}
+
+func TestRunWarmUp(t *testing.T) {
+	u := &countUnit{}
+	r := kebench.NewRunner(time.Now)
+	r.WarmUp = kebench.WarmUp{Concurrency: 2, Total: 10}
+	if _, err := r.Run(context.Background(), u, 4, 100); nil != err {
+		t.Fatal(err)
+	}
+	if 10 != u.warms || 100 != u.runs {
+		t.Errorf("warms %d runs %d, want 10 and 100", u.warms, u.runs)
+	}
+
+	u = &countUnit{}
+	r.WarmUp = kebench.WarmUp{}
+	if _, err := r.Run(context.Background(), u, 4, 100); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != u.warms {
+		t.Errorf("warms %d, want none", u.warms)
+	}
+}
+
+func TestRunWarmUpAdaptive(t *testing.T) {
+	u := &countUnit{sleep: time.Millisecond}
+	r := kebench.NewRunner(time.Now)
+	r.WarmUp = kebench.WarmUp{Total: 10000, Stable: 0.5, Window: 10}
+	if _, err := r.Run(context.Background(), u, 2, 10); nil != err {
+		t.Fatal(err)
+	}
+	if u.warms >= 10000 {
+		t.Errorf("warms %d, want the warm-up to settle early", u.warms)
+	}
+}
+
+func TestRunWarmUpInvalid(t *testing.T) {
+	var created int64
+	r := kebench.NewRunner(time.Now)
+	r.WarmUp = kebench.WarmUp{Concurrency: -1, Total: 10}
+	_, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+		atomic.AddInt64(&created, 1)
+		return &workerUnit{id: id}, nil
+	}, kebench.Load{Concurrency: 2, Total: 10})
+	if kebench.ErrConcurrency != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrConcurrency)
+	}
+	// refused before any worker was set up
+	if 0 != created {
+		t.Errorf("%d units created", created)
+	}
+}
+
+type workerUnit struct {
+	id           int
+	runs         int64
+	begun, ended bool
+}
+
+func (u *workerUnit) WarmUp(ctx context.Context) error { return nil }
+func (u *workerUnit) Begin() error                     { u.begun = true; return nil }
+func (u *workerUnit) End() error                       { u.ended = true; return nil }
+
+func (u *workerUnit) Run(ctx context.Context) error {
+	// unsynchronised on purpose, the race detector flags shared units
+	u.runs++
+	return nil
+}
+
+func TestBenchUnits(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		units = map[int]*workerUnit{}
+	)
+	r := kebench.NewRunner(time.Now)
+	_, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+		if 3 == id {
+			return nil, errors.New("no seat")
+		}
+		u := &workerUnit{id: id}
+		mtx.Lock()
+		units[id] = u
+		mtx.Unlock()
+		return u, nil
+	}, kebench.Load{Concurrency: 4, Duration: 50 * time.Millisecond})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(units) {
+		t.Errorf("units %d, want 3", len(units))
+	}
+	for id, u := range units {
+		if !u.begun || !u.ended || 0 == u.runs {
+			t.Errorf("worker %d begun %v ended %v runs %d", id, u.begun, u.ended, u.runs)
+		}
+	}
+}
+
+func TestBenchUnitsRescaled(t *testing.T) {
+	var created, ended int64
+	r := quietRunner(t)
+	_, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+		atomic.AddInt64(&created, 1)
+		return &endingUnit{workerUnit: workerUnit{id: id}, ended: &ended}, nil
+	}, kebench.Profile{Stages: []kebench.Stage{
+		{Duration: 50 * time.Millisecond, Concurrency: 6},
+		{Duration: 50 * time.Millisecond, Concurrency: 1},
+		{Duration: 50 * time.Millisecond, Concurrency: 6},
+		{Duration: 50 * time.Millisecond, Concurrency: 1},
+		{Duration: 50 * time.Millisecond, Concurrency: 6},
+	}})
+	if nil != err {
+		t.Fatal(err)
+	}
+	// parked workers are taken up again rather than replaced
+	if created > 8 || created != ended {
+		t.Errorf("%d units created, %d ended, for at most 6 workers", created, ended)
+	}
+}
+
+// endingUnit counts the workerUnits ended.
+type endingUnit struct {
+	workerUnit
+	ended *int64
+}
+
+func (u *endingUnit) End() error {
+	atomic.AddInt64(u.ended, 1)
+	return u.workerUnit.End()
+}
+
+func TestBenchUnitsNoneSetUp(t *testing.T) {
+	r := quietRunner(t)
+	goroutines := runtime.NumGoroutine()
+	for _, load := range []kebench.Load{{Concurrency: 2, Total: 10}, {Concurrency: 2, Total: 10, Rate: 100}} {
+		rep, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+			return nil, errors.New("no seat")
+		}, load)
+		var failure kebench.WorkerError
+		if !errors.Is(err, kebench.ErrWorkerSetup) || !errors.As(err, &failure) || !failure.Setup || nil != rep {
+			t.Errorf("rate %g: report %v, err %v", load.Rate, rep, err)
+		}
+	}
+	// the dispatch of the open-loop bench is gone too
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left of %d", n, goroutines)
+	}
+}
+
+func TestBenchScenario(t *testing.T) {
+	var reads, writes, scans int64
+	s := kebench.NewScenario().
+		Add("read", 70, func(context.Context) error {
+			atomic.AddInt64(&reads, 1)
+			return nil
+		}).
+		Add("write", 25, func(context.Context) error {
+			atomic.AddInt64(&writes, 1)
+			return nil
+		}).
+		Add("scan", 5, func(context.Context) error {
+			atomic.AddInt64(&scans, 1)
+			return errors.New("scan failed")
+		})
+	s.Warm = func(context.Context) error { return nil }
+	r := kebench.NewRunner(time.Now)
+	rep, err := r.Bench(context.Background(), s, kebench.Load{Concurrency: 4, Total: 10000})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(rep.Operations) || "scan" != rep.Operations[2].Name {
+		t.Fatalf("operations %+v", rep.Operations)
+	}
+	if scans != rep.Operations[2].Errors || 0 != rep.Operations[0].Errors {
+		t.Errorf("operation errors %d/%d, want %d/0", rep.Operations[2].Errors, rep.Operations[0].Errors, scans)
+	}
+	if 10000 != reads+writes+scans {
+		t.Fatalf("requests %d, want 10000", reads+writes+scans)
+	}
+	if reads < 6500 || reads > 7500 || writes < 2000 || writes > 3000 || scans < 200 || scans > 800 {
+		t.Errorf("mix %d/%d/%d, want about 7000/2500/500", reads, writes, scans)
+	}
+}
+
+func TestBenchScenarioInvalid(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	s := kebench.NewScenario().Add("read", 0, func(context.Context) error { return nil })
+	if _, err := r.Bench(context.Background(), s, kebench.Load{Concurrency: 1, Total: 1}); !errors.Is(err, kebench.ErrWeight) {
+		t.Errorf("err %v, want %v", err, kebench.ErrWeight)
+	}
+}
+
+func TestBenchUnitsScenarioInvalid(t *testing.T) {
+	r := quietRunner(t)
+	rep, err := r.BenchUnits(context.Background(), func(id int) (kebench.ContextUnit, error) {
+		return kebench.NewScenario().Add("read", 0, func(context.Context) error { return nil }), nil
+	}, kebench.Load{Concurrency: 2, Total: 10})
+	if !errors.Is(err, kebench.ErrWorkerSetup) || !errors.Is(err, kebench.ErrWeight) || nil != rep {
+		t.Errorf("report %v, err %v, want %v", rep, err, kebench.ErrWeight)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	u := &countUnit{sleep: time.Millisecond}
+	r := quietRunner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	begin := time.Now()
+	rep, err := r.RunFor(ctx, u, 4, 10*time.Second)
+	if context.Canceled != err {
+		t.Errorf("err %v, want %v", err, context.Canceled)
+	}
+	if cost := time.Since(begin); cost > time.Second {
+		t.Errorf("cancel took %v", cost)
+	}
+	if 0 == atomic.LoadInt32(&u.ended) {
+		t.Error("unit not ended")
+	}
+	// the requests cut short by the cancel are not counted
+	if nil == rep {
+		t.Fatal("no partial report")
+	}
+	if !rep.Partial || 0 == rep.Requests || atomic.LoadInt64(&u.runs)-rep.Requests > 4 {
+		t.Errorf("partial %v report of %d requests, %d runs", rep.Partial, rep.Requests, u.runs)
+	}
+	for _, f := range []struct {
+		write  kebench.ReportFormat
+		banner string
+	}{
+		{(*kebench.Report).WriteText, "PARTIAL REPORT"},
+		{(*kebench.Report).WriteMarkdown, "**Partial report**"},
+	} {
+		var buf bytes.Buffer
+		if err := f.write(rep, &buf); nil != err {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), f.banner) {
+			t.Errorf("no %q banner in\n%s", f.banner, buf.String())
+		}
+	}
+}
+
+func TestRunCancelNotAbandoned(t *testing.T) {
+	u := &countUnit{sleep: 300 * time.Millisecond}
+	r := quietRunner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	rep, err := r.RunFor(ctx, u, 4, 10*time.Second)
+	if context.Canceled != err || nil == rep {
+		t.Fatalf("report %v, err %v", rep, err)
+	}
+	// the handlers cut off by the cancel did not time out
+	if leaks := rep.Leaks; 0 != leaks.Abandoned || 0 != leaks.InFlight || 0 != rep.ErrorTypes[kebench.ErrTimeout.Error()] {
+		t.Errorf("leaks %+v, errors %v", leaks, rep.ErrorTypes)
+	}
+}
+
+func TestRunCancelWarmUp(t *testing.T) {
+	r := quietRunner(t)
+	r.WarmUp = kebench.WarmUp{Duration: 10 * time.Second}
+	load := kebench.Load{Concurrency: 2, Total: 10}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	shared := &workerUnit{}
+	if _, err := r.Bench(ctx, shared, load); context.Canceled != err {
+		t.Errorf("err %v, want %v", err, context.Canceled)
+	}
+	// the bench never began, so the unit is not ended either
+	if shared.begun || shared.ended {
+		t.Errorf("shared unit begun %v ended %v", shared.begun, shared.ended)
+	}
+
+	var (
+		mtx   sync.Mutex
+		units []*workerUnit
+	)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := r.BenchUnits(ctx, func(id int) (kebench.ContextUnit, error) {
+		u := &workerUnit{id: id}
+		mtx.Lock()
+		units = append(units, u)
+		mtx.Unlock()
+		return u, nil
+	}, load)
+	if context.Canceled != err {
+		t.Errorf("err %v, want %v", err, context.Canceled)
+	}
+	// the workers set up by the warm-up are torn down
+	if 0 == len(units) {
+		t.Fatal("no worker set up")
+	}
+	for _, u := range units {
+		if !u.begun || !u.ended {
+			t.Errorf("worker %d begun %v ended %v", u.id, u.begun, u.ended)
+		}
+	}
+}
+
+func TestRunCancelOpenLoop(t *testing.T) {
+	u := &countUnit{sleep: time.Second}
+	r := quietRunner(t)
+	r.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := r.RunLoad(ctx, u, kebench.Load{Concurrency: 2, Duration: 10 * time.Second, Rate: 100})
+	if context.DeadlineExceeded != err {
+		t.Errorf("err %v, want %v", err, context.DeadlineExceeded)
+	}
+	if cost := time.Since(begin); cost > time.Second {
+		t.Errorf("cancel took %v", cost)
+	}
+}
+
+func TestRunIntervals(t *testing.T) {
+	r := quietRunner(t)
+	r.Interval = 50 * time.Millisecond
+	rep, err := r.RunFor(context.Background(), &countUnit{sleep: time.Millisecond}, 4, 220*time.Millisecond)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// the 20ms tail folds into the fourth interval
+	if 4 != len(rep.Intervals) {
+		t.Fatalf("%d intervals", len(rep.Intervals))
+	}
+	var n int64
+	for i, in := range rep.Intervals {
+		n += in.Requests
+		if in.Offset != time.Duration(i)*r.Interval {
+			t.Errorf("interval %d at %v", i, in.Offset)
+		}
+		if last := len(rep.Intervals) - 1; i < last && in.Duration != r.Interval || i == last && in.Duration < r.Interval+r.Interval/3 {
+			t.Errorf("interval %d for %v", i, in.Duration)
+		}
+	}
+	if n != rep.Requests {
+		t.Errorf("intervals count %d requests, report %d", n, rep.Requests)
+	}
+}
+
+// trialUnit is slow in its second trial only.
+type trialUnit struct {
+	trials int32
+}
+
+func (u *trialUnit) WarmUp(ctx context.Context) error { return nil }
+func (u *trialUnit) End() error                       { return nil }
+
+func (u *trialUnit) Begin() error {
+	atomic.AddInt32(&u.trials, 1)
+	return nil
+}
+
+func (u *trialUnit) Run(ctx context.Context) error {
+	if 2 == atomic.LoadInt32(&u.trials) {
+		time.Sleep(10 * time.Millisecond)
+	} else {
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func TestRunTrials(t *testing.T) {
+	r := quietRunner(t)
+	r.Trials = 3
+	r.Cooldown = 10 * time.Millisecond
+	// tail percentiles of trials this short are noise, the median is not
+	r.Percentiles = []float64{0.5}
+	unit := &trialUnit{}
+	rep, err := r.Bench(context.Background(), unit, kebench.Load{Concurrency: 2, Duration: 100 * time.Millisecond})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil == rep.Trials || 3 != len(rep.Trials.Runs) || 3 != atomic.LoadInt32(&unit.trials) {
+		t.Fatalf("trials %+v", rep.Trials)
+	}
+	var n int64
+	for i, run := range rep.Trials.Runs {
+		n += run.Requests
+		if outlier := 0 != len(run.Outlier); outlier != (1 == i) {
+			t.Errorf("trial %d outlier in %v", i+1, run.Outlier)
+		}
+	}
+	if n != rep.Requests {
+		t.Errorf("trials count %d requests, report %d", n, rep.Requests)
+	}
+	if e := rep.Trials.TPS; e.Margin <= 0 || e.Mean-e.Margin > rep.Trials.Runs[0].TPS {
+		t.Errorf("tps %f ± %f", e.Mean, e.Margin)
+	}
+}
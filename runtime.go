@@ -0,0 +1,181 @@
+package kebench
+
+import (
+	"math"
+	rtmetrics "runtime/metrics"
+)
+
+// RuntimeReport is what the Go runtime of the load generator did while the
+// bench ran, to tell its own GC and scheduling apart from the target's.
+// Allocations count everything the process allocated, the Runner's own
+// bookkeeping of each request included, BytesPerOp and AllocsPerOp divide
+// them by the requests like go test -benchmem. GCPause and SchedLatency
+// are drawn from the runtime's histograms at their bucket bounds.
+// Goroutines spans the readings at begin, end and every host sample.
+type RuntimeReport struct {
+	GCCycles     uint64
+	GCPause      Stats
+	AllocBytes   uint64
+	AllocObjects uint64
+	BytesPerOp   float64
+	AllocsPerOp  float64
+	Goroutines   Gauge
+	SchedLatency Stats
+}
+
+// runtime metrics read, the pause distribution by its pre go1.22 name
+// when the newer one is missing
+var runtimeMetrics = []string{
+	"/gc/cycles/total:gc-cycles",
+	"/gc/heap/allocs:bytes",
+	"/gc/heap/allocs:objects",
+	"/sched/goroutines:goroutines",
+	"/sched/latencies:seconds",
+	"/sched/pauses/total/gc:seconds",
+	"/gc/pauses:seconds",
+}
+
+// runtimeReading is what the runtime metrics read at a time.
+type runtimeReading struct {
+	cycles     uint64
+	bytes      uint64
+	objects    uint64
+	goroutines uint64
+	sched      *rtmetrics.Float64Histogram
+	pauses     *rtmetrics.Float64Histogram
+}
+
+func readRuntime() runtimeReading {
+	samples := make([]rtmetrics.Sample, len(runtimeMetrics))
+	for i, name := range runtimeMetrics {
+		samples[i].Name = name
+	}
+	rtmetrics.Read(samples)
+	var rt runtimeReading
+	uint64Of := func(s rtmetrics.Sample) uint64 {
+		if rtmetrics.KindUint64 != s.Value.Kind() {
+			return 0
+		}
+		return s.Value.Uint64()
+	}
+	histogramOf := func(s rtmetrics.Sample) *rtmetrics.Float64Histogram {
+		if rtmetrics.KindFloat64Histogram != s.Value.Kind() {
+			return nil
+		}
+		return s.Value.Float64Histogram()
+	}
+	rt.cycles = uint64Of(samples[0])
+	rt.bytes = uint64Of(samples[1])
+	rt.objects = uint64Of(samples[2])
+	rt.goroutines = uint64Of(samples[3])
+	rt.sched = histogramOf(samples[4])
+	if rt.pauses = histogramOf(samples[5]); nil == rt.pauses {
+		rt.pauses = histogramOf(samples[6])
+	}
+	return rt
+}
+
+// between is the histogram of what the runtime recorded from a to b, each
+// bucket counted at its upper bound or its lower one when that is
+// infinite.
+func between(a, b *rtmetrics.Float64Histogram, precision int) *Histogram {
+	h := NewHistogram(precision)
+	if nil == b {
+		return h
+	}
+	for i, n := range b.Counts {
+		if nil != a && i < len(a.Counts) {
+			n -= a.Counts[i]
+		}
+		if 0 == n {
+			continue
+		}
+		v := b.Buckets[i+1]
+		if math.IsInf(v, 1) {
+			v = b.Buckets[i]
+		}
+		h.recordN(int64(math.Max(v, 0)*1e9), int64(n))
+	}
+	return h
+}
+
+// runtimeRecords is what the runtime did during a bench, mergeable over
+// trials.
+type runtimeRecords struct {
+	cycles     uint64
+	bytes      uint64
+	objects    uint64
+	pauses     *Histogram
+	sched      *Histogram
+	goroutines []float64
+}
+
+// runtimeBetween is what happened between the readings begin and end,
+// with the goroutines of the host samples taken meanwhile.
+func (r *Runner) runtimeBetween(begin, end runtimeReading, host []HostSample) *runtimeRecords {
+	rt := &runtimeRecords{
+		cycles:     end.cycles - begin.cycles,
+		bytes:      end.bytes - begin.bytes,
+		objects:    end.objects - begin.objects,
+		pauses:     between(begin.pauses, end.pauses, r.Precision),
+		sched:      between(begin.sched, end.sched, r.Precision),
+		goroutines: []float64{float64(begin.goroutines), float64(end.goroutines)},
+	}
+	for _, s := range host {
+		rt.goroutines = append(rt.goroutines, float64(s.Goroutines))
+	}
+	return rt
+}
+
+func (rt *runtimeRecords) merge(o *runtimeRecords) {
+	if nil == rt || nil == o {
+		return
+	}
+	rt.cycles += o.cycles
+	rt.bytes += o.bytes
+	rt.objects += o.objects
+	rt.pauses.Merge(o.pauses)
+	rt.sched.Merge(o.sched)
+	rt.goroutines = append(rt.goroutines, o.goroutines...)
+}
+
+func (rt *runtimeRecords) report(requests int64, percentiles []float64, method PercentileMethod) *RuntimeReport {
+	rep := &RuntimeReport{
+		GCCycles:     rt.cycles,
+		GCPause:      rt.pauses.stats(percentiles, method),
+		AllocBytes:   rt.bytes,
+		AllocObjects: rt.objects,
+		SchedLatency: rt.sched.stats(percentiles, method),
+		Goroutines:   Gauge{Min: math.Inf(1), Max: math.Inf(-1)},
+	}
+	if 0 != requests {
+		rep.BytesPerOp = float64(rep.AllocBytes) / float64(requests)
+		rep.AllocsPerOp = float64(rep.AllocObjects) / float64(requests)
+	}
+	for _, n := range rt.goroutines {
+		rep.Goroutines.Min, rep.Goroutines.Max = math.Min(rep.Goroutines.Min, n), math.Max(rep.Goroutines.Max, n)
+		rep.Goroutines.Mean += n
+	}
+	rep.Goroutines.Mean /= float64(len(rt.goroutines))
+	return rep
+}
+
+func (rt *RuntimeReport) write(t *textWriter) {
+	t.printf("Runtime: %.0f B/op, %.1f allocs/op, %d B and %d objects allocated, GC cycles: %d\n",
+		rt.BytesPerOp, rt.AllocsPerOp, rt.AllocBytes, rt.AllocObjects, rt.GCCycles)
+	t.printf("Runtime Goroutines (min/mean/max): %.0f/%.0f/%.0f\n", rt.Goroutines.Min, rt.Goroutines.Mean, rt.Goroutines.Max)
+	if 0 != rt.GCPause.Count {
+		t.printf("GC Pauses: %d, Total: %v, Max: %v", rt.GCPause.Count, rt.GCPause.Sum, rt.GCPause.Max)
+		for _, q := range rt.GCPause.Percentiles {
+			t.printf(", P%g: %v", q.Q*100, q.Value)
+		}
+		t.printf("\n")
+	}
+	if 0 != rt.SchedLatency.Count {
+		t.printf("Sched Latency: Mean: %v, Max: %v", rt.SchedLatency.Mean, rt.SchedLatency.Max)
+		for _, q := range rt.SchedLatency.Percentiles {
+			t.printf(", P%g: %v", q.Q*100, q.Value)
+		}
+		t.printf("\n")
+	}
+}
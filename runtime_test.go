@@ -0,0 +1,45 @@
+package kebench_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var sink [][]byte
+
+type allocUnit struct{}
+
+func (allocUnit) WarmUp() error { return nil }
+func (allocUnit) Begin() error  { return nil }
+func (allocUnit) End() error    { return nil }
+
+func (allocUnit) Run() error {
+	sink = append(sink[:0], make([]byte, 4096))
+	return nil
+}
+
+func TestRuntimeReport(t *testing.T) {
+	r := quietRunner(t)
+	rep, err := r.Run(context.Background(), allocUnit{}, 1, 2000)
+	if nil != err {
+		t.Fatal(err)
+	}
+	rt := rep.Runtime
+	if nil == rt {
+		t.Fatal("no runtime report")
+	}
+	if rt.BytesPerOp < 4096 || rt.AllocsPerOp < 1 || rt.AllocBytes < 2000*4096 {
+		t.Errorf("allocations %+v", rt)
+	}
+	if rt.Goroutines.Min < 1 || rt.Goroutines.Max < rt.Goroutines.Min {
+		t.Errorf("goroutines %+v", rt.Goroutines)
+	}
+	if 0 != rt.GCCycles && uint64(rt.GCPause.Count) < rt.GCCycles {
+		t.Errorf("%d gc cycles, %d pauses", rt.GCCycles, rt.GCPause.Count)
+	}
+	var text strings.Builder
+	if err := rep.WriteText(&text); nil != err || !strings.Contains(text.String(), "B/op") {
+		t.Errorf("text %s %v", text.String(), err)
+	}
+}
@@ -0,0 +1,445 @@
+package kebench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SampleFile is where a bench writes every request it measured, zero to
+// write none. Compress gzips the file, the loaders tell either kind apart.
+//
+// The file starts with the magic "KEBS" and a version byte, followed by
+// records of a type byte and a body: the bench parameters as JSON, the
+// name of each error class as it first appears, chunks of samples each
+// worker gathered and the end of the bench as JSON. A sample is a few
+// varints, the start as a zigzag delta to the previous one of the chunk.
+type SampleFile struct {
+	Path     string
+	Compress bool
+}
+
+// RawSample is one request read back from a sample file. Start is its
+// intended start counted from the start of the bench, Wait how long it sat
+// queued after it and Cost its service time. Err is the error it failed
+// with, empty when it did not.
+type RawSample struct {
+	Worker int
+	Start  time.Duration
+	Wait   time.Duration
+	Cost   time.Duration
+	Op     int
+	Stage  int
+	Err    string
+}
+
+// SampleInfo is what a sample file tells about its bench besides the
+// samples. Clock is the accuracy of the coarse clock it was measured with,
+// nil for the system clock.
+type SampleInfo struct {
+	Version int
+	Params  Params
+	Ops     []string
+	Wall    time.Duration
+	Partial bool
+	Limited bool
+	Leaks   LeakStats
+	Clock   *ClockAccuracy
+}
+
+const (
+	sampleMagic   = "KEBS"
+	sampleVersion = 1
+	// sampleChunk is how many bytes of samples a worker gathers before it
+	// writes them out
+	sampleChunk = 32 << 10
+)
+
+// record types of a sample file
+const (
+	sampleParams byte = iota + 1
+	sampleClass
+	sampleChunkRecord
+	sampleEnd
+)
+
+// sampleWriter writes a sample file. Workers gather samples into buffers
+// of their own and only lock it to write a full chunk, or to name an error
+// class the first time it is seen.
+type sampleWriter struct {
+	mtx     sync.Mutex
+	f       *os.File
+	gz      *gzip.Writer
+	w       *bufio.Writer
+	err     error
+	classes sync.Map
+	n       uint64
+}
+
+// createSamples creates the sample file of a bench, which start heads
+// once the bench is about to run.
+func createSamples(file SampleFile) (*sampleWriter, error) {
+	f, err := os.Create(file.Path)
+	if nil != err {
+		return nil, err
+	}
+	s := &sampleWriter{f: f}
+	if file.Compress {
+		s.gz = gzip.NewWriter(f)
+		s.w = bufio.NewWriter(s.gz)
+	} else {
+		s.w = bufio.NewWriter(f)
+	}
+	return s, nil
+}
+
+// start heads the file with params, before any sample is written.
+func (s *sampleWriter) start(params Params) {
+	s.w.WriteString(sampleMagic)
+	s.w.WriteByte(sampleVersion)
+	head, err := json.Marshal(params)
+	if nil != err {
+		s.err = err
+		return
+	}
+	s.record(sampleParams, head)
+}
+
+// record writes a record, the mutex held by the caller or nobody else
+// writing yet.
+func (s *sampleWriter) record(kind byte, body []byte) {
+	if nil != s.err {
+		return
+	}
+	var n [binary.MaxVarintLen64 + 1]byte
+	n[0] = kind
+	l := binary.PutUvarint(n[1:], uint64(len(body)))
+	if _, s.err = s.w.Write(n[:1+l]); nil == s.err {
+		_, s.err = s.w.Write(body)
+	}
+}
+
+// class numbers the error class of err, 0 for none.
+func (s *sampleWriter) class(err error) uint64 {
+	if nil == err {
+		return 0
+	}
+	name := err.Error()
+	if id, ok := s.classes.Load(name); ok {
+		return id.(uint64)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if id, ok := s.classes.Load(name); ok {
+		return id.(uint64)
+	}
+	s.n++
+	// named before any chunk using it can be written
+	body := binary.AppendUvarint(nil, s.n)
+	s.record(sampleClass, append(body, name...))
+	s.classes.Store(name, s.n)
+	return s.n
+}
+
+// close ends the file with what is known once the bench is over.
+func (s *sampleWriter) close(info SampleInfo) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tail, err := json.Marshal(info)
+	if nil != err && nil == s.err {
+		s.err = err
+	}
+	s.record(sampleEnd, tail)
+	if nil == s.err {
+		s.err = s.w.Flush()
+	}
+	// the gzip trailer and the file are closed whatever went wrong before
+	if nil != s.gz {
+		if err := s.gz.Close(); nil == s.err {
+			s.err = err
+		}
+	}
+	if err := s.f.Close(); nil == s.err {
+		s.err = err
+	}
+	return s.err
+}
+
+// discard removes the file of a bench that never ran.
+func (s *sampleWriter) discard() {
+	if nil == s {
+		return
+	}
+	if nil != s.gz {
+		s.gz.Close()
+	}
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// sampleBuffer gathers the samples of one worker.
+type sampleBuffer struct {
+	out    *sampleWriter
+	worker int
+	buf    []byte
+	count  uint64
+	prev   int64
+}
+
+func (s *sampleWriter) buffer(worker int) *sampleBuffer {
+	if nil == s {
+		return nil
+	}
+	return &sampleBuffer{out: s, worker: worker, buf: make([]byte, 0, sampleChunk+64)}
+}
+
+func (b *sampleBuffer) add(entry RecordEntry) {
+	if nil == b {
+		return
+	}
+	start := entry.At - entry.Latency()
+	b.buf = binary.AppendVarint(b.buf, start-b.prev)
+	b.buf = binary.AppendUvarint(b.buf, uint64(entry.Wait))
+	b.buf = binary.AppendUvarint(b.buf, uint64(entry.Cost))
+	b.buf = binary.AppendUvarint(b.buf, b.out.class(entry.Err))
+	b.buf = binary.AppendUvarint(b.buf, uint64(entry.Op))
+	b.buf = binary.AppendUvarint(b.buf, uint64(entry.Stage))
+	b.prev = start
+	b.count++
+	if len(b.buf) >= sampleChunk {
+		b.flush()
+	}
+}
+
+// flush writes the gathered samples as one chunk.
+func (b *sampleBuffer) flush() {
+	if nil == b || 0 == b.count {
+		return
+	}
+	head := binary.AppendUvarint(nil, uint64(b.worker))
+	head = binary.AppendUvarint(head, b.count)
+	b.out.mtx.Lock()
+	b.out.record(sampleChunkRecord, append(head, b.buf...))
+	b.out.mtx.Unlock()
+	b.buf, b.count, b.prev = b.buf[:0], 0, 0
+}
+
+// samplePath is where the samples of trial i of n go, numbered before the
+// extension when there are several.
+func samplePath(path string, i, n int) string {
+	if n <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + strconv.Itoa(i+1) + ext
+}
+
+// ReadSamples reads the sample file at path, calling fn for every sample
+// in the order they were written.
+func ReadSamples(path string, fn func(RawSample) error) (SampleInfo, error) {
+	return readSamples(path, nil, fn)
+}
+
+// readSamples reads a sample file, calling head with the bench parameters
+// before any sample. A nil fn skips the samples.
+func readSamples(path string, head func(Params) error, fn func(RawSample) error) (info SampleInfo, err error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if nil != err {
+			return info, err
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+	magic := make([]byte, len(sampleMagic)+1)
+	if _, err = io.ReadFull(r, magic); nil != err || sampleMagic != string(magic[:len(sampleMagic)]) {
+		return info, fmt.Errorf("%w: %s is no sample file", ErrSamples, path)
+	}
+	if info.Version = int(magic[len(sampleMagic)]); sampleVersion != info.Version {
+		return info, fmt.Errorf("%w: version %d", ErrSamples, info.Version)
+	}
+	classes := []string{""}
+	for {
+		kind, err := r.ReadByte()
+		if io.EOF == err {
+			return info, fmt.Errorf("%w: %s is truncated", ErrSamples, path)
+		}
+		if nil != err {
+			return info, err
+		}
+		size, err := binary.ReadUvarint(r)
+		if nil != err {
+			return info, fmt.Errorf("%w: %v", ErrSamples, err)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); nil != err {
+			return info, fmt.Errorf("%w: %v", ErrSamples, err)
+		}
+		switch kind {
+		case sampleParams:
+			if err := json.Unmarshal(body, &info.Params); nil != err {
+				return info, fmt.Errorf("%w: %v", ErrSamples, err)
+			}
+			if nil != head {
+				if err := head(info.Params); nil != err {
+					return info, err
+				}
+			}
+		case sampleClass:
+			id, n := binary.Uvarint(body)
+			if n <= 0 || id != uint64(len(classes)) {
+				return info, fmt.Errorf("%w: error class out of order", ErrSamples)
+			}
+			classes = append(classes, string(body[n:]))
+		case sampleChunkRecord:
+			if nil == fn {
+				continue
+			}
+			if err := readChunk(body, classes, fn); nil != err {
+				return info, err
+			}
+		case sampleEnd:
+			version := info.Version
+			if err := json.Unmarshal(body, &info); nil != err {
+				return info, fmt.Errorf("%w: %v", ErrSamples, err)
+			}
+			info.Version = version
+			return info, nil
+		default:
+			// records of a later minor revision, skipped
+		}
+	}
+}
+
+func readChunk(body []byte, classes []string, fn func(RawSample) error) error {
+	var (
+		off  int
+		bad  bool
+		prev int64
+	)
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(body[off:])
+		if n <= 0 {
+			bad = true
+			return 0
+		}
+		off += n
+		return v
+	}
+	worker := uvarint()
+	count := uvarint()
+	for i := uint64(0); i < count && !bad; i++ {
+		delta, n := binary.Varint(body[off:])
+		if n <= 0 {
+			return fmt.Errorf("%w: short chunk", ErrSamples)
+		}
+		off += n
+		prev += delta
+		s := RawSample{Worker: int(worker), Start: time.Duration(prev)}
+		s.Wait = time.Duration(uvarint())
+		s.Cost = time.Duration(uvarint())
+		class := uvarint()
+		s.Op = int(uvarint())
+		s.Stage = int(uvarint())
+		if bad || class >= uint64(len(classes)) {
+			return fmt.Errorf("%w: short chunk", ErrSamples)
+		}
+		s.Err = classes[class]
+		if err := fn(s); nil != err {
+			return err
+		}
+	}
+	if bad {
+		return fmt.Errorf("%w: short chunk", ErrSamples)
+	}
+	return nil
+}
+
+// Reanalyze rebuilds the report of the sample file at path with the
+// Percentiles, PercentileMethod, Interval and Precision of the Runner,
+// counting only the samples keep accepts, or all when it is nil. Rates are
+// still taken over the whole bench.
+func (r *Runner) Reanalyze(path string, keep func(RawSample) bool) (*Report, error) {
+	if err := validatePercentiles(r.Percentiles, r.PercentileMethod); nil != err {
+		return nil, err
+	}
+	if !validPrecision(r.Precision) {
+		return nil, ErrPrecision
+	}
+	// the operations are only declared at the end of the file, which is
+	// read first to tell the samples of an operation it does not have
+	info, err := readSamples(path, nil, nil)
+	if nil != err {
+		return nil, err
+	}
+	ops := max(1, len(info.Ops))
+	var (
+		p     plan
+		rec   *recorder
+		timed = newTimeline(r.Interval)
+		errs  = map[string]error{}
+	)
+	info, err = readSamples(path, func(params Params) error {
+		if 0 == len(params.Stages) {
+			return fmt.Errorf("%w: no stages", ErrSamples)
+		}
+		p = plan{stages: params.Stages, total: params.Total, arrival: params.Arrival}
+		rec = newRecorder(r.Precision, p, timed)
+		return nil
+	}, func(s RawSample) error {
+		if nil == rec || s.Stage < 0 || s.Stage >= len(p.stages) || s.Op < 0 || s.Op >= ops {
+			return fmt.Errorf("%w: sample out of its bench", ErrSamples)
+		}
+		if nil != keep && !keep(s) {
+			return nil
+		}
+		entry := RecordEntry{
+			Cost:  int64(s.Cost),
+			Wait:  int64(s.Wait),
+			At:    int64(s.Start + s.Wait + s.Cost),
+			Stage: s.Stage,
+			Op:    s.Op,
+		}
+		if "" != s.Err {
+			if entry.Err = errs[s.Err]; nil == entry.Err {
+				entry.Err = errors.New(s.Err)
+				errs[s.Err] = entry.Err
+			}
+		}
+		rec.record(entry)
+		return nil
+	})
+	if nil != err {
+		return nil, err
+	}
+	rec.flush()
+	records := rec.records(timed)
+	records.wall = info.Wall
+	records.stageWall = stageWindows(p, info.Wall)
+	records.params = info.Params
+	records.params.Interval = r.Interval
+	records.params.Method = r.PercentileMethod
+	records.ops = info.Ops
+	records.partial = info.Partial
+	records.limited = info.Limited
+	records.leak = info.Leaks
+	records.clock = info.Clock
+	return r.report(records, p), nil
+}
@@ -0,0 +1,157 @@
+package kebench_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+func TestSamples(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "bench.kebs")
+		r := quietRunner(t)
+		r.Samples = kebench.SampleFile{Path: path, Compress: compress}
+		rep, err := r.Run(context.Background(), &flakyUnit{}, 3, 900)
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		var (
+			workers = map[int]bool{}
+			starts  []time.Duration
+		)
+		info, err := kebench.ReadSamples(path, func(s kebench.RawSample) error {
+			workers[s.Worker] = true
+			starts = append(starts, s.Start)
+			if s.Start < 0 || s.Cost <= 0 || s.Start+s.Cost > rep.Wall {
+				t.Errorf("sample %+v out of the %v bench", s, rep.Wall)
+			}
+			return nil
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+		// split the samples at their median start, however short the bench
+		slices.Sort(starts)
+		mid := starts[len(starts)/2]
+		var late int64
+		for _, start := range starts {
+			if start >= mid {
+				late++
+			}
+		}
+		if 1 != info.Version || info.Wall != rep.Wall || 900 != info.Params.Total || 0 == len(workers) || len(workers) > 3 {
+			t.Errorf("info %+v, workers %v", info, workers)
+		}
+
+		back, err := r.Reanalyze(path, nil)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if back.Requests != rep.Requests || back.Errors != rep.Errors || back.Latency.Max != rep.Latency.Max ||
+			back.Latency.Percentiles[4] != rep.Latency.Percentiles[4] || back.TPS != rep.TPS {
+			t.Errorf("reanalyzed %+v, want %+v", back.Latency, rep.Latency)
+		}
+
+		r.Percentiles = []float64{0.5}
+		r.PercentileMethod = kebench.Interpolated
+		ok, err := r.Reanalyze(path, func(s kebench.RawSample) bool { return "" == s.Err })
+		if nil != err {
+			t.Fatal(err)
+		}
+		if ok.Requests != rep.Requests-rep.Errors || 0 != ok.Errors || 1 != len(ok.Latency.Percentiles) {
+			t.Errorf("successes %d of %d, errors %d, percentiles %v", ok.Requests, rep.Requests, ok.Errors, ok.Latency.Percentiles)
+		}
+
+		// only the second half of the bench
+		window, err := r.Reanalyze(path, func(s kebench.RawSample) bool { return s.Start >= mid })
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0 == late || late == rep.Requests || window.Requests != late || window.Wall != rep.Wall {
+			t.Errorf("window kept %d of %d, want %d, wall %v", window.Requests, rep.Requests, late, window.Wall)
+		}
+
+		s, err := kebench.LoadSample(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if s.Latency.Count() != rep.Requests {
+			t.Errorf("loaded %d samples, want %d", s.Latency.Count(), rep.Requests)
+		}
+	}
+}
+
+func TestSamplesMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bench.kebs")
+	if err := os.WriteFile(path, []byte("KEBS\x01\x03"), 0o644); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := kebench.NewRunner(time.Now).Reanalyze(path, nil); !errors.Is(err, kebench.ErrSamples) {
+		t.Errorf("err %v, want ErrSamples", err)
+	}
+}
+
+func TestSamplesOperation(t *testing.T) {
+	head, err := json.Marshal(kebench.Params{Stages: []kebench.Stage{{Concurrency: 1}}, Total: 1})
+	if nil != err {
+		t.Fatal(err)
+	}
+	record := func(kind byte, body []byte) []byte {
+		return append(binary.AppendUvarint([]byte{kind}, uint64(len(body))), body...)
+	}
+	file := []byte("KEBS\x01")
+	file = append(file, record(1, head)...)
+	// worker 0, one sample at 0 waiting 0, costing 1, without error,
+	// issuing an operation the file never declares
+	chunk := []byte{0, 1, 0, 0, 1, 0}
+	chunk = binary.AppendUvarint(chunk, 1<<40)
+	chunk = append(chunk, 0)
+	file = append(file, record(3, chunk)...)
+	file = append(file, record(4, []byte(`{"Wall":1000}`))...)
+	path := filepath.Join(t.TempDir(), "bench.kebs")
+	if err := os.WriteFile(path, file, 0o644); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := quietRunner(t).Reanalyze(path, nil); !errors.Is(err, kebench.ErrSamples) {
+		t.Errorf("err %v, want ErrSamples", err)
+	}
+}
+
+func TestSamplesUnwritable(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); nil != err {
+		t.Skip("no /dev/full")
+	}
+	r := quietRunner(t)
+	for _, compress := range []bool{false, true} {
+		r.Samples = kebench.SampleFile{Path: "/dev/full", Compress: compress}
+		rep, err := r.Run(context.Background(), &countUnit{}, 2, 100)
+		if !errors.Is(err, kebench.ErrSampleWrite) {
+			t.Fatalf("compress %v: err %v, want ErrSampleWrite", compress, err)
+		}
+		if nil == rep || 100 != rep.Requests {
+			t.Fatalf("compress %v: report %+v", compress, rep)
+		}
+	}
+}
+
+func TestSamplesUncreatable(t *testing.T) {
+	r := quietRunner(t)
+	r.Samples = kebench.SampleFile{Path: filepath.Join(t.TempDir(), "missing", "bench.kebs")}
+	u := &workerUnit{}
+	if _, err := r.Bench(context.Background(), u, kebench.Load{Concurrency: 2, Total: 100}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err %v, want %v", err, os.ErrNotExist)
+	}
+	// the bench failed before the unit was begun
+	if u.begun || u.ended {
+		t.Errorf("unit begun %v ended %v", u.begun, u.ended)
+	}
+}
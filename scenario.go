@@ -0,0 +1,184 @@
+package kebench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Operation is one named request type of a Scenario. Weight is its share
+// of the mix relative to the other operations.
+type Operation struct {
+	Name   string
+	Weight float64
+	Run    ContextHandler
+}
+
+// Scenario is a unit whose requests are a weighted mix of operations,
+// e.g. 70% reads, 25% writes and 5% scans. The Runner picks an operation
+// for every request and reports each one on its own next to the total.
+// Workers built by a UnitFactory may each return their own Scenario, as
+// long as all of them list the same operations in the same order.
+//
+// Warm is the warm-up request and defaults to the mix itself. Setup and
+// Teardown, when set, are called as Begin and End.
+type Scenario struct {
+	Operations []Operation
+	Warm       ContextHandler
+	Setup      func() error
+	Teardown   func() error
+}
+
+// NewScenario returns an empty scenario to Add operations to.
+func NewScenario() *Scenario {
+	return &Scenario{}
+}
+
+// Add registers an operation and returns s for chaining.
+func (s *Scenario) Add(name string, weight float64, run ContextHandler) *Scenario {
+	s.Operations = append(s.Operations, Operation{Name: name, Weight: weight, Run: run})
+	return s
+}
+
+func (s *Scenario) validate() error {
+	if 0 == len(s.Operations) {
+		return ErrNoOperations
+	}
+	for _, op := range s.Operations {
+		if "" == op.Name || nil == op.Run {
+			return fmt.Errorf("operation %q: %w", op.Name, ErrOperation)
+		}
+		if op.Weight <= 0 {
+			return fmt.Errorf("operation %q: %w", op.Name, ErrWeight)
+		}
+	}
+	return nil
+}
+
+func (s *Scenario) names() []string {
+	names := make([]string, len(s.Operations))
+	for i, op := range s.Operations {
+		names[i] = op.Name
+	}
+	return names
+}
+
+// mix returns a picker drawing operations by weight from its own RNG, so
+// workers do not contend on a shared source.
+func (s *Scenario) mix() mix {
+	var (
+		sum     float64
+		bounds  = make([]float64, len(s.Operations))
+		rng     = rand.New(rand.NewSource(time.Now().UnixNano()))
+		last    = len(s.Operations) - 1
+		runners = make([]ContextHandler, len(s.Operations))
+	)
+	for i, op := range s.Operations {
+		sum += op.Weight
+		bounds[i] = sum
+		runners[i] = op.Run
+	}
+	return func() (int, ContextHandler) {
+		n := rng.Float64() * sum
+		i := min(sort.SearchFloat64s(bounds, n), last)
+		return i, runners[i]
+	}
+}
+
+// Run issues one operation picked by weight. The Runner does not go
+// through it, it picks operations itself to tell them apart.
+func (s *Scenario) Run(ctx context.Context) error {
+	var sum float64
+	for _, op := range s.Operations {
+		sum += op.Weight
+	}
+	n := rand.Float64() * sum
+	for _, op := range s.Operations {
+		if n < op.Weight {
+			return op.Run(ctx)
+		}
+		n -= op.Weight
+	}
+	return s.Operations[len(s.Operations)-1].Run(ctx)
+}
+
+func (s *Scenario) WarmUp(ctx context.Context) error {
+	if nil != s.Warm {
+		return s.Warm(ctx)
+	}
+	return s.Run(ctx)
+}
+
+func (s *Scenario) Begin() error {
+	if nil != s.Setup {
+		return s.Setup()
+	}
+	return nil
+}
+
+func (s *Scenario) End() error {
+	if nil != s.Teardown {
+		return s.Teardown()
+	}
+	return nil
+}
+
+// mix picks the operation a worker issues next.
+type mix func() (op int, handler ContextHandler)
+
+// single is the mix of a unit with one kind of request.
+func single(handler ContextHandler) mix {
+	return func() (int, ContextHandler) {
+		return 0, handler
+	}
+}
+
+// mixOf is what a worker of unit issues in the warm-up or the bench.
+func mixOf(unit ContextUnit, warm bool) (mix, error) {
+	s, ok := unit.(*Scenario)
+	if !ok {
+		if warm {
+			return single(unit.WarmUp), nil
+		}
+		return single(unit.Run), nil
+	}
+	if warm && nil != s.Warm {
+		return single(s.Warm), nil
+	}
+	if err := s.validate(); nil != err {
+		return nil, err
+	}
+	return s.mix(), nil
+}
+
+// validateUnit checks what can be checked of unit before a run.
+func validateUnit(unit ContextUnit) error {
+	if s, ok := unit.(*Scenario); ok {
+		return s.validate()
+	}
+	return nil
+}
+
+// operationsOf names the operations of unit, nil unless it is a Scenario.
+func operationsOf(unit ContextUnit) []string {
+	if s, ok := unit.(*Scenario); ok {
+		return s.names()
+	}
+	return nil
+}
+
+// operationReports splits records by the scenario operation they issued.
+func operationReports(records Records, wall time.Duration, percentiles []float64, method PercentileMethod) []OperationReport {
+	reports := make([]OperationReport, len(records.ops))
+	for i, name := range records.ops {
+		reports[i].Name = name
+		var t tally
+		if i < len(records.byOp) {
+			t = records.byOp[i]
+		}
+		reports[i].summarize(t, wall, percentiles, method)
+	}
+	return reports
+}
@@ -0,0 +1,262 @@
+package kebench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SLO is the service level a load has to keep: the Percentile latency,
+// e.g. 0.99, must stay below Latency and the share of failed requests
+// at or below ErrorRate. A zero Latency or ErrorRate is not checked,
+// NoErrors is how to ask for every request to succeed.
+type SLO struct {
+	Percentile float64
+	Latency    time.Duration
+	ErrorRate  float64
+	NoErrors   bool
+}
+
+func (s SLO) validate() error {
+	if err := validatePercentiles([]float64{s.Percentile}, NearestRank); nil != err {
+		return err
+	}
+	if s.Latency < 0 || s.ErrorRate < 0 {
+		return ErrSLO
+	}
+	return nil
+}
+
+// Search looks for the highest load that keeps the SLO. It probes
+// concurrency from Min up to Max, or the open-loop arrival rate when Rate
+// is set, each probe running for Step. The level doubles until the SLO
+// breaks and is then bisected until the gap between the best passing and
+// the first failing level is within Precision, a ratio such as 0.05.
+// Concurrency caps the requests in flight of a rate search.
+type Search struct {
+	SLO         SLO
+	Rate        bool
+	Min, Max    float64
+	Step        time.Duration
+	Precision   float64
+	Concurrency int
+	Arrival     Arrival
+}
+
+func (s Search) validate() error {
+	if err := s.SLO.validate(); nil != err {
+		return err
+	}
+	if s.Min <= 0 || s.Max < s.Min {
+		return ErrSearchRange
+	}
+	if s.Step <= 0 {
+		return ErrSearchStep
+	}
+	if s.Rate && s.Concurrency < 1 {
+		return ErrConcurrency
+	}
+	return nil
+}
+
+// keeps reports whether a probe that failed errorRate of its requests
+// within latency kept the SLO.
+func (s SLO) keeps(latency time.Duration, errorRate float64) bool {
+	if 0 != s.Latency && latency >= s.Latency {
+		return false
+	}
+	if s.NoErrors {
+		return 0 == errorRate
+	}
+	return 0 == s.ErrorRate || errorRate <= s.ErrorRate
+}
+
+// level rounds a searched value to something the Runner can run, at
+// least a single worker.
+func (s Search) level(v float64) float64 {
+	if s.Rate {
+		return v
+	}
+	return max(1, math.Round(v))
+}
+
+func (s Search) load(level float64) Load {
+	if s.Rate {
+		return Load{Concurrency: s.Concurrency, Duration: s.Step, Rate: level, Arrival: s.Arrival}
+	}
+	return Load{Concurrency: int(level), Duration: s.Step}
+}
+
+// close reports whether lo and hi are near enough to stop bisecting.
+func (s Search) close(lo, hi float64) bool {
+	if !s.Rate && hi-lo <= 1 {
+		return true
+	}
+	return (hi-lo)/lo <= max(s.Precision, 1e-3)
+}
+
+// SearchPoint is one probe of a search: the level it ran at, what it
+// achieved and whether that kept the SLO.
+type SearchPoint struct {
+	Level     float64
+	TPS       float64
+	Latency   time.Duration
+	ErrorRate float64
+	OK        bool
+}
+
+// SearchResult is the outcome of a search. Best is the highest level
+// that kept the SLO, valid when Found is set, and Curve every probe in
+// ascending order of level.
+type SearchResult struct {
+	Best  SearchPoint
+	Found bool
+	Curve []SearchPoint
+}
+
+// Search finds the highest sustainable load of unit under search.SLO.
+func (r *Runner) Search(ctx context.Context, unit ContextUnit, search Search) (SearchResult, error) {
+	if err := validateUnit(unit); nil != err {
+		return SearchResult{}, err
+	}
+	return r.search(ctx, &sharedUnit{unit: unit}, search)
+}
+
+// SearchUnits is Search with a unit per worker created by factory.
+func (r *Runner) SearchUnits(ctx context.Context, factory UnitFactory, search Search) (SearchResult, error) {
+	return r.search(ctx, newWorkerUnits(factory), search)
+}
+
+func (r *Runner) search(ctx context.Context, units units, s Search) (result SearchResult, err error) {
+	if err = s.validate(); nil != err {
+		return
+	}
+	if err = validatePercentiles(nil, r.PercentileMethod); nil != err {
+		return
+	}
+	warm := s.load(s.level(s.Max)).plan()
+	if err = r.WarmUp.validate(warm); nil != err {
+		return
+	}
+	if !validPrecision(r.Precision) {
+		return result, ErrPrecision
+	}
+	if err = r.validateClock(); nil != err {
+		return
+	}
+	stop, err := r.serveMetrics()
+	if nil != err {
+		return
+	}
+	defer stop()
+	if r.WarmUp.enabled() {
+		fmt.Println("start warmup")
+		r.metrics.enter(phaseWarmUp)
+		var summary WarmUpSummary
+		summary, err = r.warmUp(ctx, units.handlers(true), warm)
+		if nil != err {
+			units.end()
+			return
+		}
+		summary.print()
+	}
+	if err = units.begin(); nil != err {
+		units.end()
+		return
+	}
+	fmt.Println("start search")
+	r.metrics.enter(phaseSearch)
+
+	run := units.handlers(false)
+	probe := func(level float64) SearchPoint {
+		point := r.probe(ctx, run, s, level)
+		if nil != ctx.Err() {
+			// cut short by cancellation, it says nothing about the level
+			point.OK = false
+			return point
+		}
+		result.Curve = append(result.Curve, point)
+		if point.OK && (!result.Found || point.Level > result.Best.Level) {
+			result.Best, result.Found = point, true
+		}
+		return point
+	}
+
+	// grow until the SLO breaks, then bisect between pass and fail
+	lo, hi := 0.0, 0.0
+	for level := s.level(s.Min); nil == ctx.Err(); level = s.level(min(level*2, s.Max)) {
+		if !probe(level).OK {
+			hi = level
+			break
+		}
+		lo = level
+		if level >= s.Max {
+			break
+		}
+	}
+	for 0 != lo && 0 != hi && !s.close(lo, hi) && nil == ctx.Err() {
+		mid := s.level((lo + hi) / 2)
+		if mid <= lo || mid >= hi {
+			break
+		}
+		if probe(mid).OK {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	err = units.end()
+	for _, failure := range units.failures() {
+		fmt.Println(failure.Error())
+	}
+	sort.Slice(result.Curve, func(i, j int) bool {
+		return result.Curve[i].Level < result.Curve[j].Level
+	})
+	if cerr := ctx.Err(); nil != cerr {
+		fmt.Println("PARTIAL SEARCH: canceled, the interrupted probe is dropped")
+		err = cerr
+	}
+	result.print(s)
+	return
+}
+
+func (r *Runner) probe(ctx context.Context, run handlers, s Search, level float64) SearchPoint {
+	begin := r.Clock.Now()
+	records := r.benching(ctx, run, s.load(level).plan(), nil, nil)
+	cost := r.Clock.Now().Sub(begin)
+
+	point := SearchPoint{Level: level}
+	if n := records.all.count(); 0 != n {
+		point.TPS = float64(n) / cost.Seconds()
+		point.Latency = time.Duration(records.all.latency.Quantile(s.SLO.Percentile, r.PercentileMethod))
+		point.ErrorRate = float64(records.all.errors) / float64(n)
+		point.OK = s.SLO.keeps(point.Latency, point.ErrorRate) && !records.limited
+	}
+	fmt.Printf("probe %s: TPS: %.2f, P%g: %v, Error Rate: %.2f%%, SLO kept: %v\n",
+		s.describe(level), point.TPS, s.SLO.Percentile*100, point.Latency, point.ErrorRate*100, point.OK)
+	return point
+}
+
+func (s Search) describe(level float64) string {
+	if s.Rate {
+		return fmt.Sprintf("rate %.2f", level)
+	}
+	return fmt.Sprintf("concurrency %d", int(level))
+}
+
+func (res SearchResult) print(s Search) {
+	fmt.Println("Latency-Throughput Curve:")
+	for _, point := range res.Curve {
+		fmt.Printf("%s: TPS: %.2f, P%g: %v, Error Rate: %.2f%%, SLO kept: %v\n",
+			s.describe(point.Level), point.TPS, s.SLO.Percentile*100, point.Latency, point.ErrorRate*100, point.OK)
+	}
+	if !res.Found {
+		fmt.Println("No load kept the SLO")
+		return
+	}
+	fmt.Printf("Sustainable Max: %s, TPS: %.2f, P%g: %v\n",
+		s.describe(res.Best.Level), res.Best.TPS, s.SLO.Percentile*100, res.Best.Latency)
+}
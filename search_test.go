@@ -0,0 +1,103 @@
+package kebench_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kebench "github.com/jsn4ke/ke_bench"
+)
+
+// crowdUnit slows down by a millisecond for every request in flight.
+type crowdUnit struct {
+	inflight int64
+}
+
+func (u *crowdUnit) WarmUp(ctx context.Context) error { return nil }
+func (u *crowdUnit) Begin() error                     { return nil }
+func (u *crowdUnit) End() error                       { return nil }
+
+func (u *crowdUnit) Run(ctx context.Context) error {
+	n := atomic.AddInt64(&u.inflight, 1)
+	defer atomic.AddInt64(&u.inflight, -1)
+	time.Sleep(time.Duration(n) * time.Millisecond)
+	return nil
+}
+
+func TestSearchConcurrency(t *testing.T) {
+	r := quietRunner(t)
+	res, err := r.Search(context.Background(), &crowdUnit{}, kebench.Search{
+		SLO:  kebench.SLO{Percentile: 0.9, Latency: 6 * time.Millisecond},
+		Min:  1,
+		Max:  64,
+		Step: 50 * time.Millisecond,
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !res.Found {
+		t.Fatal("no sustainable load found")
+	}
+	if res.Best.Level < 2 || res.Best.Level > 6 {
+		t.Errorf("best concurrency %v, want about 4", res.Best.Level)
+	}
+	for i := 1; i < len(res.Curve); i++ {
+		if res.Curve[i-1].Level > res.Curve[i].Level {
+			t.Errorf("curve not sorted at %d", i)
+		}
+	}
+}
+
+func TestSearchInvalid(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	_, err := r.Search(context.Background(), &crowdUnit{}, kebench.Search{
+		SLO:  kebench.SLO{Percentile: 0.99},
+		Min:  8,
+		Max:  4,
+		Step: time.Millisecond,
+	})
+	if kebench.ErrSearchRange != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrSearchRange)
+	}
+}
+
+func TestSearchMethodInvalid(t *testing.T) {
+	r := kebench.NewRunner(time.Now)
+	r.PercentileMethod = -1
+	_, err := r.Search(context.Background(), &crowdUnit{}, kebench.Search{
+		SLO:  kebench.SLO{Percentile: 0.99},
+		Min:  1,
+		Max:  4,
+		Step: time.Millisecond,
+	})
+	if kebench.ErrPercentileMethod != err {
+		t.Errorf("err %v, want %v", err, kebench.ErrPercentileMethod)
+	}
+}
+
+func TestSearchNoErrors(t *testing.T) {
+	r := quietRunner(t)
+	search := kebench.Search{
+		SLO:  kebench.SLO{Percentile: 0.9, NoErrors: true},
+		Min:  0.2,
+		Max:  2,
+		Step: 50 * time.Millisecond,
+	}
+	res, err := r.Search(context.Background(), kebench.Adapt(&flakyUnit{}), search)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// a level rounding to no worker still probes with one
+	if res.Found || 0 == len(res.Curve) || 1 != res.Curve[0].Level || 0 == res.Curve[0].ErrorRate {
+		t.Errorf("found %v, curve %+v, want every probe to fail", res.Found, res.Curve)
+	}
+
+	search.SLO = kebench.SLO{Percentile: 0.9}
+	if res, err = r.Search(context.Background(), kebench.Adapt(&flakyUnit{}), search); nil != err {
+		t.Fatal(err)
+	}
+	if !res.Found {
+		t.Errorf("curve %+v, want the errors not checked", res.Curve)
+	}
+}
@@ -0,0 +1,170 @@
+package kebench
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// steerInterval is how often the worker count follows a ramping profile.
+const steerInterval = 10 * time.Millisecond
+
+// Stage is one step of a load profile. It lasts Duration and targets
+// Concurrency workers and, in open-loop profiles, Rate requests per
+// second. With Ramp set the level moves linearly from the previous
+// stage's level to this one over Duration, otherwise it jumps straight
+// there and holds.
+type Stage struct {
+	Duration    time.Duration
+	Concurrency int
+	Rate        float64
+	Ramp        bool
+}
+
+// Profile is a list of stages run back to back, e.g. ramp up, hold,
+// spike and ramp down. It runs open-loop when any stage sets a Rate;
+// Concurrency then caps the requests in flight.
+type Profile struct {
+	Stages  []Stage
+	Arrival Arrival
+}
+
+func (p Profile) validate() error {
+	if 0 == len(p.Stages) {
+		return ErrNoStages
+	}
+	for _, s := range p.Stages {
+		if s.Concurrency < 1 {
+			return ErrConcurrency
+		}
+		if s.Duration <= 0 {
+			return ErrStageDuration
+		}
+		if s.Rate < 0 {
+			return ErrRate
+		}
+	}
+	return nil
+}
+
+func (p Profile) plan() plan {
+	return plan{
+		stages:  p.Stages,
+		arrival: p.Arrival,
+	}
+}
+
+// RunProfile warms up and benches unit following the stages of profile.
+func (r *Runner) RunProfile(ctx context.Context, unit Unit, profile Profile) (*Report, error) {
+	return r.Bench(ctx, Adapt(unit), profile)
+}
+
+// steer keeps the worker crew at the level the plan schedules until the
+// phase is stopped or has run out of work, publishing the current stage
+// for closed-loop workers to tag their records with.
+func (r *Runner) steer(p plan, start time.Time, stop, drained *halt, stage *int32, workers *crew) {
+	ticker := time.NewTicker(steerInterval)
+	defer ticker.Stop()
+	for {
+		i, n, _ := p.level(r.Clock.Now().Sub(start))
+		atomic.StoreInt32(stage, int32(i))
+		workers.resize(n)
+		select {
+		case <-stop.done:
+			return
+		case <-drained.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// crew is a resizable set of workers. Shrinking raises the quit flag of
+// the newest workers, which leave after finishing their current request.
+// Growing takes up the lowest id of a worker that has left, so its unit
+// and connections are used again instead of piling up, and only counts on
+// once none is free. An id is free once its worker has returned, so a
+// worker on its way out never shares per-worker state with its
+// replacement, and only if its work reported it fit to be taken up again.
+// empty is called whenever the last running worker has left.
+type crew struct {
+	work  func(id int, quit *int32) bool
+	empty func()
+	quits []*int32
+	next  int
+	live  int32
+	wg    sync.WaitGroup
+	mtx   sync.Mutex
+	free  []int
+}
+
+// id is the id of a new worker.
+func (c *crew) id() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if 0 == len(c.free) {
+		c.next++
+		return c.next - 1
+	}
+	slices.Sort(c.free)
+	id := c.free[0]
+	c.free = c.free[1:]
+	return id
+}
+
+func (c *crew) resize(n int) {
+	for len(c.quits) < n {
+		id, quit := c.id(), new(int32)
+		c.quits = append(c.quits, quit)
+		c.wg.Add(1)
+		atomic.AddInt32(&c.live, 1)
+		go func() {
+			defer c.wg.Done()
+			if c.work(id, quit) {
+				c.mtx.Lock()
+				c.free = append(c.free, id)
+				c.mtx.Unlock()
+			}
+			if 0 == atomic.AddInt32(&c.live, -1) && nil != c.empty {
+				c.empty()
+			}
+		}()
+	}
+	for len(c.quits) > n {
+		atomic.StoreInt32(c.quits[len(c.quits)-1], 1)
+		c.quits = c.quits[:len(c.quits)-1]
+	}
+}
+
+func (c *crew) wait() {
+	c.wg.Wait()
+}
+
+// stageWindows is how long each stage of p ran, timed against the
+// schedule and cut at the real end of a bench that ran for wall.
+func stageWindows(p plan, wall time.Duration) []time.Duration {
+	var (
+		offset  time.Duration
+		windows = make([]time.Duration, len(p.stages))
+	)
+	for i, s := range p.stages {
+		windows[i] = max(min(offset+s.Duration, wall)-offset, 0)
+		offset += s.Duration
+	}
+	return windows
+}
+
+// stageReports splits records by the stage they started in.
+func stageReports(records Records, p plan, percentiles []float64, method PercentileMethod) []StageReport {
+	reports := make([]StageReport, len(p.stages))
+	for i, s := range p.stages {
+		reports[i] = StageReport{
+			Stage:  s,
+			Window: records.stageWall[i],
+		}
+		reports[i].summarize(records.byStage[i], records.stageWall[i], percentiles, method)
+	}
+	return reports
+}
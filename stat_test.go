@@ -13,3 +13,48 @@ func TestCpu(t *testing.T) {
 	per2, _ := cpu.Percent(time.Second, false)
 	fmt.Println(per1, per2)
 }
+
+func TestHostSampleFailedRead(t *testing.T) {
+	at := time.Now()
+	prev := hostReading{at: at}
+	h := hostReading{at: at.Add(time.Second), ctxt: 5000, sent: 1 << 40, recv: 1 << 40, hasCtxt: true, hasNet: true}
+	// the counters since boot are no rate over the second
+	if s := h.sample(prev, at); 0 != s.ContextSwitches || 0 != s.NetSent || 0 != s.NetRecv {
+		t.Errorf("rates %v %v %v after a failed read", s.ContextSwitches, s.NetSent, s.NetRecv)
+	}
+	// nor does a failed read wrap around
+	failed := hostReading{at: h.at.Add(time.Second)}
+	if s := failed.sample(h, at); 0 != s.ContextSwitches || 0 != s.NetSent || 0 != s.NetRecv {
+		t.Errorf("rates %v %v %v of a failed read", s.ContextSwitches, s.NetSent, s.NetRecv)
+	}
+	next := h
+	next.at, next.ctxt, next.sent = failed.at, h.ctxt+100, h.sent+10
+	if s := next.sample(h, at); 100 != s.ContextSwitches || 10 != s.NetSent {
+		t.Errorf("rates %v %v, want 100 and 10", s.ContextSwitches, s.NetSent)
+	}
+}
+
+func TestTimelineCollect(t *testing.T) {
+	width := 100 * time.Millisecond
+	s := newTimeline(width)
+	rec := newRecorder(DefaultPrecision, plan{stages: []Stage{{}}}, s)
+	// a worker that records nothing after the interval is still closed
+	rec.record(RecordEntry{Cost: int64(time.Millisecond), At: int64(width / 2)})
+	s.collect(0)
+	if 1 != len(s.tallies) || 1 != s.tallies[0].count() {
+		t.Fatalf("interval 0 not collected: %d tallies", len(s.tallies))
+	}
+	// nor does it take the interval in progress
+	rec.record(RecordEntry{Cost: int64(time.Millisecond), At: int64(2 * width)})
+	s.collect(1)
+	if 1 != len(s.tallies) {
+		t.Errorf("interval 2 collected with 1: %d tallies", len(s.tallies))
+	}
+	// the last 10ms of a 310ms phase fold into its third interval
+	if n := spans(310*time.Millisecond, width); 3 != n || 110*time.Millisecond != spanOf(2, n, 310*time.Millisecond, width) {
+		t.Errorf("%d spans, the last for %v", n, spanOf(2, n, 310*time.Millisecond, width))
+	}
+	if n := spans(360*time.Millisecond, width); 4 != n {
+		t.Errorf("%d spans of 360ms", n)
+	}
+}
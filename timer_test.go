@@ -2,31 +2,11 @@ package kebench_test
 
 import (
 	"fmt"
-	"sync"
-	"sync/atomic"
 	"testing"
 	"time"
-)
-
-type stimer struct {
-	sync.Once
-	now int64
-}
-
-func (t *stimer) run() {
-	t.Do(func() {
-		atomic.StoreInt64(&t.now, time.Now().UnixNano())
-		go func() {
-			for now := range time.Tick(time.Microsecond) {
-				atomic.StoreInt64(&t.now, now.UnixNano())
-			}
-		}()
-	})
-}
 
-func (t *stimer) Now() time.Time {
-	return time.Unix(0, atomic.LoadInt64(&t.now))
-}
+	kebench "github.com/jsn4ke/ke_bench"
+)
 
 func TestTimer(t *testing.T) {
 	t.Run("Timer-1-1", func(t *testing.T) {
@@ -36,12 +16,13 @@ func TestTimer(t *testing.T) {
 		}
 	})
 	t.Run("Timer-2-1", func(t *testing.T) {
-		st := &stimer{}
-		st.run()
+		st := kebench.NewCachedClock(time.Microsecond)
+		defer st.Stop()
 		for i := 0; i < 10; i++ {
 			fmt.Println(st.Now())
 			time.Sleep(time.Second)
 		}
+		fmt.Printf("%+v\n", st.Accuracy())
 	})
 }
 
@@ -52,8 +33,8 @@ func BenchmarkTimer(b *testing.B) {
 		}
 	})
 	b.Run("Timer-2-1", func(b *testing.B) {
-		t := &stimer{}
-		t.run()
+		t := kebench.NewCachedClock(time.Microsecond)
+		defer t.Stop()
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			t.Now()
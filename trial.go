@@ -0,0 +1,288 @@
+package kebench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// trialConfidence is the confidence level of the trial estimates.
+	trialConfidence = 0.95
+	// outlierMADs is how many scaled median absolute deviations a trial
+	// may stray from the median of all trials before it is flagged.
+	outlierMADs = 3
+	// outlierShare is the least relative deviation from the median that
+	// flags a trial, so trials agreeing to a few percent never are.
+	outlierShare = 0.05
+)
+
+// TrialSummary tells how far the trials of a bench agree. Runs holds every
+// trial in order, TPS and Percentiles the mean over the completed ones
+// with the margin of their confidence interval.
+type TrialSummary struct {
+	Confidence  float64
+	Runs        []TrialRun
+	TPS         Estimate
+	Percentiles []Estimate
+}
+
+// TrialRun is the outcome of one trial. Outlier names the statistics in
+// which it strays from the other trials.
+type TrialRun struct {
+	Wall     time.Duration
+	Requests int64
+	Errors   int64
+	TPS      float64
+	Latency  []Quantile
+	Partial  bool
+	Outlier  []string
+}
+
+// Estimate is a statistic over trials, Mean ± Margin. Q is the percentile
+// it estimates, zero for TPS.
+type Estimate struct {
+	Q      float64
+	Mean   float64
+	Margin float64
+}
+
+// repeat runs Trials trials, reporting all their requests together along
+// with how the trials compare. Intervals are left out of the report as the
+// trials share no timeline.
+func (r *Runner) repeat(ctx context.Context, units units, p plan) (*Report, error) {
+	var (
+		merged Records
+		runs   []TrialRun
+		err    error
+		serr   error
+	)
+	for i := 0; i < r.Trials; i++ {
+		if 0 != i && 0 != r.Cooldown {
+			fmt.Printf("cooldown %v\n", r.Cooldown)
+			r.metrics.enter(phaseCooldown)
+			timer := time.NewTimer(r.Cooldown)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		if nil != ctx.Err() && 0 != i {
+			break
+		}
+		fmt.Printf("trial %d/%d\n", i+1, r.Trials)
+		records, terr := r.trial(ctx, units, p, i)
+		if nil != terr {
+			if 0 == i {
+				return nil, terr
+			}
+			err = terr
+			break
+		}
+		if nil == serr {
+			serr = records.samples
+		}
+		run := r.report(records, p)
+		runs = append(runs, TrialRun{
+			Wall:     run.Wall,
+			Requests: run.Requests,
+			Errors:   run.Errors,
+			TPS:      run.TPS,
+			Latency:  run.Latency.Percentiles,
+			Partial:  run.Partial,
+		})
+		fmt.Printf("trial %d: ", i+1)
+		writeSummary(&textWriter{w: os.Stdout}, Summary{
+			Requests: run.Requests, Errors: run.Errors, ErrorRate: run.ErrorRate,
+			TPS: run.TPS, Latency: run.Latency,
+		})
+		if 0 == i {
+			merged = records
+		} else {
+			merged.merge(records)
+		}
+	}
+	report := r.report(merged, p)
+	report.Intervals = nil
+	report.Trials = summarizeTrials(runs, r.Percentiles)
+	if oerr := r.output(report); nil != oerr && nil == err {
+		err = oerr
+	}
+	if nil == err {
+		err = ctx.Err()
+	}
+	if nil == err {
+		err = serr
+	}
+	return report, err
+}
+
+// summarizeTrials estimates TPS and percentiles over the completed runs
+// and flags the outliers among them.
+func summarizeTrials(runs []TrialRun, percentiles []float64) *TrialSummary {
+	summary := &TrialSummary{Confidence: trialConfidence, Runs: runs}
+	var done []int
+	for i, run := range runs {
+		if !run.Partial {
+			done = append(done, i)
+		}
+	}
+	values := make([]float64, len(done))
+	estimate := func(name string, q float64, value func(run TrialRun) float64) Estimate {
+		for j, i := range done {
+			values[j] = value(runs[i])
+		}
+		for _, j := range outliers(values) {
+			runs[done[j]].Outlier = append(runs[done[j]].Outlier, name)
+		}
+		e := Estimate{Q: q}
+		e.Mean, e.Margin = confidence(values)
+		return e
+	}
+	summary.TPS = estimate("TPS", 0, func(run TrialRun) float64 { return run.TPS })
+	for k, q := range percentiles {
+		summary.Percentiles = append(summary.Percentiles, estimate(
+			"P"+strconv.FormatFloat(q*100, 'g', -1, 64), q,
+			func(run TrialRun) float64 { return float64(run.Latency[k].Value) }))
+	}
+	return summary
+}
+
+// confidence is the mean of values and the margin of its confidence
+// interval by Student's t, zero for fewer than two values.
+func confidence(values []float64) (mean, margin float64) {
+	n := float64(len(values))
+	if 0 == n {
+		return
+	}
+	for _, v := range values {
+		mean += v
+	}
+	mean /= n
+	if n < 2 {
+		return
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, studentT(len(values)-1) * math.Sqrt(sq/(n-1)/n)
+}
+
+// studentT is the two-sided 95% critical value of Student's t with df
+// degrees of freedom.
+func studentT(df int) float64 {
+	table := [...]float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	switch {
+	case df <= len(table):
+		return table[df-1]
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	}
+	return 1.960
+}
+
+// outliers are the indexes of the values more than outlierMADs scaled
+// median absolute deviations and outlierShare away from their median.
+// It takes at least three values to tell.
+func outliers(values []float64) []int {
+	if len(values) < 3 {
+		return nil
+	}
+	med := median(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+	// 1.4826 scales the MAD to a standard deviation for normal data
+	bound := math.Max(outlierMADs*1.4826*median(deviations), outlierShare*math.Abs(med))
+	var out []int
+	for i, d := range deviations {
+		if d > bound {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if 0 == n%2 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// merge adds the records of a later trial of the same plan.
+func (rs *Records) merge(o Records) {
+	rs.all.merge(o.all)
+	if nil != rs.service {
+		rs.service.Merge(o.service)
+		rs.queue.Merge(o.queue)
+	}
+	for len(rs.byOp) < len(o.byOp) {
+		rs.byOp = append(rs.byOp, tally{})
+	}
+	for i, t := range o.byOp {
+		rs.byOp[i].merge(t)
+	}
+	for i, t := range o.byStage {
+		rs.byStage[i].merge(t)
+	}
+	for i, w := range o.stageWall {
+		rs.stageWall[i] += w
+	}
+	rs.intervals = nil
+	rs.limited = rs.limited || o.limited
+	rs.partial = rs.partial || o.partial
+	rs.leak.Abandoned += o.leak.Abandoned
+	rs.leak.Late += o.leak.Late
+	rs.leak.InFlight = o.leak.InFlight
+	rs.leak.Peak = max(rs.leak.Peak, o.leak.Peak)
+	// the failures of worker units add up over the trials already
+	rs.workers = o.workers
+	if 0 == len(rs.ops) {
+		rs.ops = o.ops
+	}
+	rs.wall += o.wall
+	rs.warm = o.warm
+	rs.host = append(rs.host, o.host...)
+	rs.runtime.merge(o.runtime)
+	rs.profiles = append(rs.profiles, o.profiles...)
+	rs.clock = coarser(rs.clock, o.clock)
+}
+
+func (s *TrialSummary) write(t *textWriter) {
+	t.printf("Trials: %d, %g%% confidence\n", len(s.Runs), s.Confidence*100)
+	for i, run := range s.Runs {
+		t.printf("Trial %d (%v): Requests: %d, Errors: %d, TPS: %.2f", i+1, run.Wall, run.Requests, run.Errors, run.TPS)
+		for _, q := range run.Latency {
+			t.printf(", P%g: %v", q.Q*100, q.Value)
+		}
+		if run.Partial {
+			t.printf(", partial")
+		}
+		if 0 != len(run.Outlier) {
+			t.printf(", OUTLIER in %s", strings.Join(run.Outlier, " "))
+		}
+		t.printf("\n")
+	}
+	t.printf("TPS: %.2f ± %.2f\n", s.TPS.Mean, s.TPS.Margin)
+	for _, e := range s.Percentiles {
+		t.printf("Cost at %.2f%%: %v ± %v\n", e.Q*100, time.Duration(e.Mean), time.Duration(e.Margin))
+	}
+}
@@ -0,0 +1,197 @@
+package kebench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// UnitFactory creates the unit of one worker, so every virtual user can
+// own its connection, codec and RNG instead of sharing them. id counts
+// from 0 and is stable across warm-up and bench, a worker with the same
+// id in both phases gets the same unit.
+//
+// Begin of a worker unit is its setup, called once before its first
+// request, and End its teardown, called after the bench.
+//
+// A worker does not wait for a handler abandoned by a timeout, so its unit
+// can see overlapping Run calls after one, and End while such a handler
+// is still running. A unit that cannot cope should honour the request
+// context and return once it is done.
+type UnitFactory func(id int) (ContextUnit, error)
+
+// WorkerError is a worker whose unit failed to set up or tear down.
+// A worker that fails to set up issues no requests, the rest of the bench
+// carries on without it. A bench none of whose workers set up fails with
+// ErrWorkerSetup, wrapping their WorkerErrors.
+type WorkerError struct {
+	Worker int
+	Setup  bool
+	Err    error
+}
+
+func (e WorkerError) Error() string {
+	if e.Setup {
+		return fmt.Sprintf("worker %d setup: %v", e.Worker, e.Err)
+	}
+	return fmt.Sprintf("worker %d teardown: %v", e.Worker, e.Err)
+}
+
+func (e WorkerError) Unwrap() error {
+	return e.Err
+}
+
+// BenchUnits warms up and benches a unit per worker, created by factory,
+// following schedule.
+func (r *Runner) BenchUnits(ctx context.Context, factory UnitFactory, schedule Schedule) (*Report, error) {
+	return r.measure(ctx, newWorkerUnits(factory), schedule)
+}
+
+// handlers resolves what a worker issues, failing when the worker could
+// not be set up.
+type handlers func(worker int) (mix, error)
+
+// units is where the handlers of a bench come from.
+type units interface {
+	handlers(warm bool) handlers
+	operations() []string
+	begin() error
+	// end tears down what begin and the handlers set up, and only that
+	end() error
+	failures() []WorkerError
+}
+
+// sharedUnit hands the same unit to every worker. It is only ended once
+// begun, a bench cut short before that leaves it alone.
+type sharedUnit struct {
+	unit  ContextUnit
+	begun bool
+}
+
+func (s *sharedUnit) handlers(warm bool) handlers {
+	return func(int) (mix, error) {
+		return mixOf(s.unit, warm)
+	}
+}
+
+func (s *sharedUnit) operations() []string { return operationsOf(s.unit) }
+
+func (s *sharedUnit) begin() error {
+	if err := s.unit.Begin(); nil != err {
+		return err
+	}
+	s.begun = true
+	return nil
+}
+
+func (s *sharedUnit) end() error {
+	if !s.begun {
+		return nil
+	}
+	s.begun = false
+	return s.unit.End()
+}
+
+func (s *sharedUnit) failures() []WorkerError { return nil }
+
+// workerUnits creates a unit per worker on first use and keeps it until
+// the bench is over.
+type workerUnits struct {
+	factory UnitFactory
+	mtx     sync.Mutex
+	units   map[int]ContextUnit
+	errs    []WorkerError
+}
+
+func newWorkerUnits(factory UnitFactory) *workerUnits {
+	return &workerUnits{factory: factory, units: map[int]ContextUnit{}}
+}
+
+func (w *workerUnits) unit(id int) (ContextUnit, error) {
+	w.mtx.Lock()
+	unit, ok := w.units[id]
+	w.mtx.Unlock()
+	if ok {
+		if nil == unit {
+			return nil, ErrWorkerSetup
+		}
+		return unit, nil
+	}
+	// ids are unique among running workers, nobody else sets this one up
+	unit, err := w.factory(id)
+	if nil == err {
+		err = validateUnit(unit)
+	}
+	if nil == err {
+		err = unit.Begin()
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if nil != err {
+		// remember the failure so the worker is not set up again
+		w.units[id] = nil
+		w.errs = append(w.errs, WorkerError{Worker: id, Setup: true, Err: err})
+		return nil, err
+	}
+	w.units[id] = unit
+	return unit, nil
+}
+
+func (w *workerUnits) handlers(warm bool) handlers {
+	return func(id int) (mix, error) {
+		unit, err := w.unit(id)
+		if nil != err {
+			return nil, err
+		}
+		return mixOf(unit, warm)
+	}
+}
+
+// operations names the operations of the first unit that was set up.
+func (w *workerUnits) operations() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	ids := make([]int, 0, len(w.units))
+	for id, unit := range w.units {
+		if nil != unit {
+			ids = append(ids, id)
+		}
+	}
+	if 0 == len(ids) {
+		return nil
+	}
+	sort.Ints(ids)
+	return operationsOf(w.units[ids[0]])
+}
+
+func (w *workerUnits) begin() error { return nil }
+
+func (w *workerUnits) end() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	ids := make([]int, 0, len(w.units))
+	for id, unit := range w.units {
+		if nil != unit {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if err := w.units[id].End(); nil != err {
+			w.errs = append(w.errs, WorkerError{Worker: id, Err: err})
+		}
+	}
+	// another trial sets its workers up afresh
+	w.units = map[int]ContextUnit{}
+	return nil
+}
+
+func (w *workerUnits) failures() []WorkerError {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	sort.SliceStable(w.errs, func(i, j int) bool {
+		return w.errs[i].Worker < w.errs[j].Worker
+	})
+	return w.errs
+}
@@ -0,0 +1,165 @@
+package kebench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DefaultWarmUp is the warm-up of a new Runner.
+var DefaultWarmUp = WarmUp{Total: 1000, Duration: 10 * time.Second}
+
+const (
+	// defaultWarmUpWindow is the window size of an adaptive warm-up
+	// that does not set one.
+	defaultWarmUpWindow = 200
+	// stableWindows is how many recent windows an adaptive warm-up
+	// compares to decide whether latency has settled.
+	stableWindows = 5
+)
+
+// WarmUp configures the closed-loop phase run before the measurement.
+// It stops after Total requests or once Duration has elapsed, whichever
+// comes first, and leaving both zero skips the warm-up. Concurrency
+// defaults to the highest concurrency of the bench.
+//
+// A positive Stable makes the warm-up adaptive: it runs in windows of
+// Window requests and ends as soon as the mean latencies of the last few
+// windows have a coefficient of variation below Stable, e.g. 0.05.
+type WarmUp struct {
+	Concurrency int
+	Total       int64
+	Duration    time.Duration
+	Stable      float64
+	Window      int64
+}
+
+func (w WarmUp) enabled() bool {
+	return 0 != w.Total || 0 != w.Duration
+}
+
+// WarmUpSummary tells how the warm-up went. Means holds the mean latency
+// of every window of an adaptive warm-up, Steady whether it settled and
+// CV the last coefficient of variation it measured.
+type WarmUpSummary struct {
+	Requests int64
+	Errors   int64
+	Cost     time.Duration
+	Means    []time.Duration
+	Steady   bool
+	CV       float64
+}
+
+func (s *WarmUpSummary) add(records Records) {
+	s.Errors += records.all.errors
+	if n := records.all.count(); 0 != n {
+		s.Requests += n
+		s.Means = append(s.Means, time.Duration(records.all.latency.Mean()))
+	}
+}
+
+// load is the closed-loop load the warm-up issues ahead of p.
+func (w WarmUp) load(p plan) Load {
+	load := Load{
+		Concurrency: w.Concurrency,
+		Total:       w.Total,
+		Duration:    w.Duration,
+	}
+	if 0 == load.Concurrency {
+		load.Concurrency = p.maxConcurrency()
+	}
+	return load
+}
+
+// validate checks the warm-up ahead of p before any unit is set up.
+func (w WarmUp) validate(p plan) error {
+	if !w.enabled() {
+		return nil
+	}
+	return w.load(p).validate()
+}
+
+func (r *Runner) warmUp(ctx context.Context, handler handlers, p plan) (summary WarmUpSummary, err error) {
+	w := r.WarmUp
+	load := w.load(p)
+	if err = load.validate(); nil != err {
+		return
+	}
+	begin := r.Clock.Now()
+	if w.Stable <= 0 {
+		summary.add(r.benching(ctx, handler, load.plan(), nil, nil))
+		summary.Cost = r.Clock.Now().Sub(begin)
+		return
+	}
+
+	window := w.Window
+	if window <= 0 {
+		window = defaultWarmUpWindow
+	}
+	for nil == ctx.Err() {
+		step := load
+		step.Total = window
+		if 0 != w.Total {
+			if summary.Requests >= w.Total {
+				break
+			}
+			step.Total = min(window, w.Total-summary.Requests)
+		}
+		if 0 != w.Duration {
+			step.Duration = w.Duration - r.Clock.Now().Sub(begin)
+			if step.Duration <= 0 {
+				break
+			}
+		}
+		before := summary.Requests
+		summary.add(r.benching(ctx, handler, step.plan(), nil, nil))
+		if before == summary.Requests {
+			break
+		}
+		if len(summary.Means) >= stableWindows {
+			summary.CV = variation(summary.Means[len(summary.Means)-stableWindows:])
+			if summary.CV < w.Stable {
+				summary.Steady = true
+				break
+			}
+		}
+	}
+	summary.Cost = r.Clock.Now().Sub(begin)
+	return
+}
+
+// variation is the coefficient of variation of values.
+func variation(values []time.Duration) float64 {
+	var mean float64
+	for _, v := range values {
+		mean += float64(v)
+	}
+	mean /= float64(len(values))
+	if 0 == mean {
+		return 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (float64(v) - mean) * (float64(v) - mean)
+	}
+	return math.Sqrt(sq/float64(len(values))) / mean
+}
+
+func (s WarmUpSummary) print() {
+	fmt.Printf("warmup %d requests, %d errors in %v\n", s.Requests, s.Errors, s.Cost)
+	if len(s.Means) < 2 {
+		return
+	}
+	means := make([]string, len(s.Means))
+	for i, mean := range s.Means {
+		means[i] = mean.String()
+	}
+	fmt.Printf("warmup window means: %s\n", strings.Join(means, " "))
+	if s.Steady {
+		fmt.Printf("warmup steady after %d windows, cv %.4f\n", len(s.Means), s.CV)
+	} else {
+		fmt.Printf("warmup not steady, last cv %.4f\n", s.CV)
+	}
+}